@@ -1,23 +1,34 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"net/url"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
+	"httpfromtcp/internal/cache"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
 	"httpfromtcp/internal/server"
+	"httpfromtcp/internal/sse"
+	"httpfromtcp/internal/websocket"
 	"maps"
 )
 
 const port = 42069
 
+// proxyHandler shares one response cache across all /httpbin requests.
+var proxyHandler = server.ProxyHandler{
+	Transport: &cache.Transport{Store: cache.NewMemoryStore(64 << 20)},
+}
+
 func main() {
 	// srv, err := server.Serve(port, handleRequest)
-	srv, err := server.ServeTLS(port, handleRequest, "certs/localhost.crt", "certs/localhost.key")
+	srv, err := server.ServeTLS(port, handleRequest, "certs/localhost.crt", "certs/localhost.key", server.WithStreamingBodies(), server.WithStrictParsing(), server.WithCertReload(time.Minute))
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -30,27 +41,37 @@ func main() {
 }
 
 func handleRequest(w *response.Writer, r *request.Request) {
-	path := r.RequestLine.RequestTarget
-
-	if strings.HasPrefix(path, "/httpbin") {
-		trimedPath := strings.TrimPrefix(path, "/httpbin")
-		proxyHandler := server.ProxyHandler{}
+	if r.Target.Path == "/httpbin" || strings.HasPrefix(r.Target.Path, "/httpbin/") {
+		// Forward the same cleaned path that was routed on, so a raw path
+		// like "/x/../httpbin/get" cannot reach the upstream unresolved.
+		trimedPath := (&url.URL{Path: strings.TrimPrefix(r.Target.Path, "/httpbin")}).EscapedPath()
+		if r.Target.RawQuery != "" {
+			trimedPath += "?" + r.Target.RawQuery
+		}
 		handler := proxyHandler.RequestHandler(trimedPath)
 		handler(w, r)
 		return
 	}
 
-	switch path {
+	server.Compress(handleLocal)(w, r)
+}
+
+func handleLocal(w *response.Writer, r *request.Request) {
+	switch r.Target.Path {
 	case "/yourproblem":
-		respondWithHTML(w, response.StatusCodeBadRequest, "400 Bad Request", "Your request honestly kinda sucked.")
+		respondWithError(w, response.StatusCodeBadRequest, "Your request honestly kinda sucked.")
 	case "/myproblem":
-		respondWithHTML(w, response.StatusCodeServerError, "500 Internal Server Error", "Okay, you know what? This one is on me.")
+		respondWithError(w, response.StatusCodeServerError, "Okay, you know what? This one is on me.")
+	case "/ws":
+		handleWebSocket(w, r)
+	case "/events":
+		handleEvents(w, r)
 	case "/video":
 		if r.RequestLine.Method == "GET" {
 			f, err := os.ReadFile("assets/vim.mp4")
 			if err != nil {
 				log.Printf("Error reading file: %v", err)
-				respondWithHTML(w, response.StatusCodeServerError, "500 Internal Server Error", "Sorry, I couldn't find the video.")
+				respondWithError(w, response.StatusCodeServerError, "Sorry, I couldn't find the video.")
 				return
 			}
 			w.WriteStatusLine(response.StatusCodeOk)
@@ -65,6 +86,57 @@ func handleRequest(w *response.Writer, r *request.Request) {
 	}
 }
 
+// handleWebSocket echoes every message back to the client.
+func handleWebSocket(w *response.Writer, r *request.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		log.Printf("Error upgrading connection: %v", err)
+		var hsErr *websocket.HandshakeError
+		if errors.As(err, &hsErr) {
+			hErr := &server.HandlerError{StatusCode: hsErr.StatusCode, Message: hsErr.Error(), Headers: hsErr.Headers}
+			if err := hErr.Write(w); err != nil {
+				log.Printf("Error writing error response: %v", err)
+			}
+		}
+		return
+	}
+	defer conn.Close(websocket.CloseNormalClosure, "")
+
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(messageType, message); err != nil {
+			log.Printf("Error writing message: %v", err)
+			return
+		}
+	}
+}
+
+// handleEvents pushes the current time to the client every second.
+func handleEvents(w *response.Writer, r *request.Request) {
+	stream, err := sse.NewStream(w, r, 15*time.Second)
+	if err != nil {
+		log.Printf("Error starting event stream: %v", err)
+		return
+	}
+	defer stream.Close()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := stream.Send(sse.Event{Event: "tick", Data: now.Format(time.RFC3339)}); err != nil {
+				return
+			}
+		case <-stream.Done():
+			return
+		}
+	}
+}
+
 func respondWithHTML(w *response.Writer, statusCode response.StatusCode, title, message string, extraHeaders ...map[string]string) {
 	body := []byte(`
 <html>
@@ -91,6 +163,14 @@ func respondWithHTML(w *response.Writer, statusCode response.StatusCode, title,
 	w.WriteBody(body)
 }
 
+// respondWithError writes an error page in the format the client prefers.
+func respondWithError(w *response.Writer, statusCode response.StatusCode, message string) {
+	hErr := &server.HandlerError{StatusCode: int(statusCode), Message: message}
+	if err := hErr.Write(w); err != nil {
+		log.Printf("Error writing error response: %v", err)
+	}
+}
+
 func waitForShutdown() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -0,0 +1,487 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a stored response together with the data needed to compute its age.
+type Entry struct {
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	RequestTime  time.Time
+	ResponseTime time.Time
+	// VaryValues holds the request header values named by the response's Vary header.
+	VaryValues map[string]string
+	// Variants is only set on the entry stored under the URL of a response
+	// with Vary. It then has no response of its own and lists the keys of
+	// the stored representations instead, one per set of VaryValues.
+	Variants []string
+}
+
+// Store keeps cache entries by key.
+type Store interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, entry *Entry)
+	Delete(key string)
+}
+
+// Transport is an http.RoundTripper implementing a shared HTTP cache (RFC 9111).
+type Transport struct {
+	Store Store
+	// Next performs requests that cannot be answered from the cache. Nil
+	// means http.DefaultTransport.
+	Next http.RoundTripper
+	// MaxEntrySize is the largest body that will be stored. Zero means 10 MiB.
+	MaxEntrySize int
+	// Now returns the current time. Nil means time.Now.
+	Now func() time.Time
+}
+
+// cacheableStatus lists the status codes that are heuristically cacheable (RFC 9110 section 15.1).
+var cacheableStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// RoundTrip answers GET and HEAD requests from the cache when a fresh entry
+// exists, revalidates stale entries and stores cacheable responses.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := cacheKey(req)
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		res, err := t.next().RoundTrip(req)
+		if err == nil && res.StatusCode < 400 && !isSafe(req.Method) {
+			// Unsafe methods invalidate what we have for the target URI.
+			t.invalidate(key)
+		}
+		return res, err
+	}
+
+	reqCC := parseCacheControl(req.Header.Get("Cache-Control"))
+	if _, ok := reqCC["no-store"]; ok {
+		return t.next().RoundTrip(req)
+	}
+
+	entry, ok := t.lookup(req, key)
+	if ok && t.canServe(entry, reqCC) {
+		return t.cachedResponse(req, entry), nil
+	}
+
+	if ok {
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			req = req.Clone(req.Context())
+			req.Header.Set("If-None-Match", etag)
+		} else if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			req = req.Clone(req.Context())
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	requestTime := t.now()
+	res, err := t.next().RoundTrip(req)
+	if err != nil {
+		if ok && allowsStaleOnError(entry) {
+			return t.cachedResponse(req, entry), nil
+		}
+		return nil, err
+	}
+	responseTime := t.now()
+
+	if ok && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		// Freshen the stored headers with those from the 304 (RFC 9111 section 4.3.4).
+		freshened := *entry
+		freshened.Header = entry.Header.Clone()
+		for name, values := range res.Header {
+			if name == "Content-Length" {
+				continue
+			}
+			freshened.Header[name] = values
+		}
+		freshened.RequestTime = requestTime
+		freshened.ResponseTime = responseTime
+		t.store(key, &freshened)
+		return t.cachedResponse(req, &freshened), nil
+	}
+
+	if !t.storable(req, res) {
+		return res, nil
+	}
+
+	newEntry := &Entry{
+		StatusCode:   res.StatusCode,
+		Header:       res.Header.Clone(),
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		VaryValues:   varyValues(req, res.Header),
+	}
+	res.Body = &teeBody{
+		body:  res.Body,
+		limit: t.maxEntrySize(),
+		done: func(body []byte) {
+			newEntry.Body = body
+			t.store(key, newEntry)
+		},
+	}
+	return res, nil
+}
+
+// lookup returns the entry stored for req under key, picking the
+// representation that matches req if the response had Vary.
+func (t *Transport) lookup(req *http.Request, key string) (*Entry, bool) {
+	entry, ok := t.Store.Get(key)
+	if ok && entry.Variants != nil {
+		values := make(map[string]string, len(entry.VaryValues))
+		for name := range entry.VaryValues {
+			values[name] = req.Header.Get(name)
+		}
+		variant := variantKey(key, values)
+		if !slices.Contains(entry.Variants, variant) {
+			return nil, false
+		}
+		entry, ok = t.Store.Get(variant)
+	}
+	if !ok || !entry.matchesVary(req) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// store saves entry under key. A response with Vary is saved under a key of
+// its own, so that each representation is kept, and listed in an index
+// entry under key.
+func (t *Transport) store(key string, entry *Entry) {
+	if len(entry.VaryValues) == 0 {
+		t.Store.Set(key, entry)
+		return
+	}
+	variant := variantKey(key, entry.VaryValues)
+	index := &Entry{VaryValues: make(map[string]string), Variants: []string{variant}}
+	for name := range entry.VaryValues {
+		index.VaryValues[name] = ""
+	}
+	if old, ok := t.Store.Get(key); ok && old.Variants != nil && sameNames(old.VaryValues, entry.VaryValues) {
+		for _, other := range old.Variants {
+			if other != variant {
+				index.Variants = append(index.Variants, other)
+			}
+		}
+	}
+	t.Store.Set(variant, entry)
+	t.Store.Set(key, index)
+}
+
+// invalidate drops what is stored under key, including every representation
+// of a response with Vary.
+func (t *Transport) invalidate(key string) {
+	if entry, ok := t.Store.Get(key); ok {
+		for _, variant := range entry.Variants {
+			t.Store.Delete(variant)
+		}
+	}
+	t.Store.Delete(key)
+}
+
+// canServe reports whether entry may be used without contacting the origin.
+func (t *Transport) canServe(entry *Entry, reqCC cacheControl) bool {
+	resCC := parseCacheControl(entry.Header.Get("Cache-Control"))
+	if _, ok := resCC["no-cache"]; ok {
+		return false
+	}
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+	if strings.Contains(strings.ToLower(entry.Header.Get("Pragma")), "no-cache") && entry.Header.Get("Cache-Control") == "" {
+		return false
+	}
+
+	age := entry.currentAge(t.now())
+	lifetime := entry.freshnessLifetime()
+
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok && lifetime-age < minFresh {
+		return false
+	}
+	if age < lifetime {
+		return true
+	}
+
+	// Stale entries may only be used if the client accepts staleness and the
+	// origin has not forbidden it.
+	if _, ok := resCC["must-revalidate"]; ok {
+		return false
+	}
+	if _, ok := resCC["proxy-revalidate"]; ok {
+		return false
+	}
+	if _, ok := resCC["s-maxage"]; ok {
+		return false
+	}
+	if value, ok := reqCC["max-stale"]; ok {
+		if value == "" {
+			return true
+		}
+		maxStale, err := strconv.Atoi(value)
+		return err == nil && age-lifetime <= time.Duration(maxStale)*time.Second
+	}
+	return false
+}
+
+// storable reports whether a shared cache may store res (RFC 9111 section 3).
+func (t *Transport) storable(req *http.Request, res *http.Response) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	// Partial content is not stored: it is neither the whole representation
+	// nor combined with other parts.
+	if res.StatusCode == http.StatusPartialContent || req.Header.Get("Range") != "" {
+		return false
+	}
+	resCC := parseCacheControl(res.Header.Get("Cache-Control"))
+	if _, ok := resCC["no-store"]; ok {
+		return false
+	}
+	if _, ok := resCC["private"]; ok {
+		return false
+	}
+	if strings.TrimSpace(res.Header.Get("Vary")) == "*" {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" {
+		_, public := resCC["public"]
+		_, sMaxAge := resCC["s-maxage"]
+		_, mustRevalidate := resCC["must-revalidate"]
+		if !public && !sMaxAge && !mustRevalidate {
+			return false
+		}
+	}
+	if res.ContentLength > int64(t.maxEntrySize()) {
+		return false
+	}
+
+	_, public := resCC["public"]
+	explicit := public || res.Header.Get("Expires") != ""
+	for _, directive := range []string{"max-age", "s-maxage", "no-cache"} {
+		if _, ok := resCC[directive]; ok {
+			explicit = true
+		}
+	}
+	return explicit || cacheableStatus[res.StatusCode]
+}
+
+// cachedResponse builds a response from entry with its current Age.
+func (t *Transport) cachedResponse(req *http.Request, entry *Entry) *http.Response {
+	header := entry.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(entry.currentAge(t.now()).Seconds())))
+	body := entry.Body
+	if req.Method == http.MethodHead {
+		body = nil
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(entry.StatusCode) + " " + http.StatusText(entry.StatusCode),
+		StatusCode:    entry.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(entry.Body)),
+		Request:       req,
+	}
+}
+
+// currentAge implements the age calculation of RFC 9111 section 4.2.3.
+func (e *Entry) currentAge(now time.Time) time.Duration {
+	apparentAge := time.Duration(0)
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		apparentAge = max(0, e.ResponseTime.Sub(date))
+	}
+	ageValue := time.Duration(0)
+	if age, err := strconv.Atoi(e.Header.Get("Age")); err == nil && age > 0 {
+		ageValue = time.Duration(age) * time.Second
+	}
+	responseDelay := e.ResponseTime.Sub(e.RequestTime)
+	correctedInitialAge := max(apparentAge, ageValue+responseDelay)
+	return correctedInitialAge + now.Sub(e.ResponseTime)
+}
+
+// freshnessLifetime implements RFC 9111 section 4.2.1 for a shared cache.
+func (e *Entry) freshnessLifetime() time.Duration {
+	cc := parseCacheControl(e.Header.Get("Cache-Control"))
+	if sMaxAge, ok := cc.seconds("s-maxage"); ok {
+		return sMaxAge
+	}
+	if maxAge, ok := cc.seconds("max-age"); ok {
+		return maxAge
+	}
+	date, dateErr := http.ParseTime(e.Header.Get("Date"))
+	if dateErr != nil {
+		date = e.ResponseTime
+	}
+	if expiresValue := e.Header.Get("Expires"); expiresValue != "" {
+		expires, err := http.ParseTime(expiresValue)
+		if err != nil {
+			// An invalid Expires means already expired.
+			return 0
+		}
+		return max(0, expires.Sub(date))
+	}
+	// Heuristic freshness: 10% of the time since the last modification.
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && cacheableStatus[e.StatusCode] {
+		return max(0, date.Sub(lastModified)/10)
+	}
+	return 0
+}
+
+// matchesVary reports whether req selects the same representation as the stored one.
+func (e *Entry) matchesVary(req *http.Request) bool {
+	for name, value := range e.VaryValues {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// variantKey is the key of the representation selected by the Vary values.
+func variantKey(key string, values map[string]string) string {
+	names := slices.Sorted(maps.Keys(values))
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range names {
+		b.WriteString("\x00" + name + ":" + values[name])
+	}
+	return b.String()
+}
+
+// sameNames reports whether a and b have the same keys.
+func sameNames(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name := range a {
+		if _, ok := b[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func varyValues(req *http.Request, header http.Header) map[string]string {
+	values := make(map[string]string)
+	for _, vary := range header.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" {
+				values[name] = req.Header.Get(name)
+			}
+		}
+	}
+	return values
+}
+
+func allowsStaleOnError(entry *Entry) bool {
+	cc := parseCacheControl(entry.Header.Get("Cache-Control"))
+	_, mustRevalidate := cc["must-revalidate"]
+	_, staleIfError := cc["stale-if-error"]
+	return staleIfError && !mustRevalidate
+}
+
+func (t *Transport) next() http.RoundTripper {
+	if t.Next == nil {
+		return http.DefaultTransport
+	}
+	return t.Next
+}
+
+func (t *Transport) now() time.Time {
+	if t.Now == nil {
+		return time.Now()
+	}
+	return t.Now()
+}
+
+func (t *Transport) maxEntrySize() int {
+	if t.MaxEntrySize == 0 {
+		return 10 << 20
+	}
+	return t.MaxEntrySize
+}
+
+func cacheKey(req *http.Request) string {
+	return req.URL.String()
+}
+
+func isSafe(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions || method == http.MethodTrace
+}
+
+// cacheControl maps directive names to their (possibly empty) values.
+type cacheControl map[string]string
+
+func parseCacheControl(value string) cacheControl {
+	cc := make(cacheControl)
+	for _, directive := range strings.Split(value, ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(directive, "=")
+		cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+	return cc
+}
+
+// seconds returns a delta-seconds directive as a duration.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	value, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// teeBody copies a response body as it is read and hands the full copy to
+// done once the body has been read to the end without exceeding limit.
+type teeBody struct {
+	body     io.ReadCloser
+	buf      bytes.Buffer
+	limit    int
+	done     func([]byte)
+	overflow bool
+}
+
+func (tb *teeBody) Read(p []byte) (int, error) {
+	n, err := tb.body.Read(p)
+	if n > 0 && !tb.overflow {
+		if tb.buf.Len()+n > tb.limit {
+			tb.overflow = true
+			tb.buf = bytes.Buffer{}
+		} else {
+			tb.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !tb.overflow && tb.done != nil {
+		tb.done(tb.buf.Bytes())
+		tb.done = nil
+	}
+	return n, err
+}
+
+func (tb *teeBody) Close() error {
+	return tb.body.Close()
+}
@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// originFunc adapts a function into an http.RoundTripper standing in for the origin.
+type originFunc func(req *http.Request) *http.Response
+
+func (f originFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func originResponse(status int, header http.Header, body string) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+func get(t *testing.T, transport http.RoundTripper, url string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	res, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	return res, string(body)
+}
+
+func TestTransportFreshness(t *testing.T) {
+	c := &clock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	hits := 0
+	origin := originFunc(func(req *http.Request) *http.Response {
+		hits++
+		return originResponse(200, http.Header{
+			"Cache-Control": {"max-age=60"},
+			"Date":          {c.now.Format(http.TimeFormat)},
+		}, "hello")
+	})
+	transport := &Transport{Store: NewMemoryStore(1 << 20), Next: origin, Now: c.Now}
+
+	// Test: First request goes to the origin
+	_, body := get(t, transport, "http://example.com/a", nil)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, 1, hits)
+
+	// Test: Fresh entry is served with its age
+	c.now = c.now.Add(30 * time.Second)
+	res, body := get(t, transport, "http://example.com/a", nil)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "30", res.Header.Get("Age"))
+	assert.Equal(t, 1, hits)
+
+	// Test: Request max-age forces a refetch
+	get(t, transport, "http://example.com/a", http.Header{"Cache-Control": {"max-age=10"}})
+	assert.Equal(t, 2, hits)
+
+	// Test: Stale entry is refetched
+	c.now = c.now.Add(61 * time.Second)
+	get(t, transport, "http://example.com/a", nil)
+	assert.Equal(t, 3, hits)
+
+	// Test: Unsafe method invalidates the entry
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com/a", nil)
+	_, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, 4, hits)
+	get(t, transport, "http://example.com/a", nil)
+	assert.Equal(t, 5, hits)
+}
+
+func TestTransportExpires(t *testing.T) {
+	c := &clock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	hits := 0
+	origin := originFunc(func(req *http.Request) *http.Response {
+		hits++
+		return originResponse(200, http.Header{
+			"Date":    {c.now.Format(http.TimeFormat)},
+			"Expires": {c.now.Add(time.Minute).Format(http.TimeFormat)},
+		}, "hello")
+	})
+	transport := &Transport{Store: NewMemoryStore(1 << 20), Next: origin, Now: c.Now}
+
+	get(t, transport, "http://example.com/", nil)
+	c.now = c.now.Add(59 * time.Second)
+	get(t, transport, "http://example.com/", nil)
+	assert.Equal(t, 1, hits)
+	c.now = c.now.Add(2 * time.Second)
+	get(t, transport, "http://example.com/", nil)
+	assert.Equal(t, 2, hits)
+}
+
+func TestTransportRevalidation(t *testing.T) {
+	c := &clock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	var conditional []string
+	origin := originFunc(func(req *http.Request) *http.Response {
+		conditional = append(conditional, req.Header.Get("If-None-Match"))
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			return originResponse(http.StatusNotModified, http.Header{"Cache-Control": {"no-cache"}, "Etag": {`"v1"`}}, "")
+		}
+		return originResponse(200, http.Header{"Cache-Control": {"no-cache"}, "Etag": {`"v1"`}}, "hello")
+	})
+	transport := &Transport{Store: NewMemoryStore(1 << 20), Next: origin, Now: c.Now}
+
+	// Test: no-cache responses are stored but always revalidated
+	_, body := get(t, transport, "http://example.com/", nil)
+	assert.Equal(t, "hello", body)
+	res, body := get(t, transport, "http://example.com/", nil)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, []string{"", `"v1"`}, conditional)
+}
+
+func TestTransportNotStored(t *testing.T) {
+	hits := 0
+	cacheControl := "no-store"
+	vary := ""
+	origin := originFunc(func(req *http.Request) *http.Response {
+		hits++
+		header := http.Header{"Cache-Control": {cacheControl}}
+		if vary != "" {
+			header.Set("Vary", vary)
+		}
+		return originResponse(200, header, "hello")
+	})
+	transport := &Transport{Store: NewMemoryStore(1 << 20), Next: origin}
+
+	// Test: no-store
+	get(t, transport, "http://example.com/", nil)
+	get(t, transport, "http://example.com/", nil)
+	assert.Equal(t, 2, hits)
+
+	// Test: private
+	cacheControl = "private, max-age=60"
+	get(t, transport, "http://example.com/", nil)
+	get(t, transport, "http://example.com/", nil)
+	assert.Equal(t, 4, hits)
+
+	// Test: Vary: *
+	cacheControl = "max-age=60"
+	vary = "*"
+	get(t, transport, "http://example.com/", nil)
+	get(t, transport, "http://example.com/", nil)
+	assert.Equal(t, 6, hits)
+
+	// Test: Vary selects between representations
+	vary = "Accept-Language"
+	get(t, transport, "http://example.com/v", http.Header{"Accept-Language": {"en"}})
+	get(t, transport, "http://example.com/v", http.Header{"Accept-Language": {"en"}})
+	assert.Equal(t, 7, hits)
+	get(t, transport, "http://example.com/v", http.Header{"Accept-Language": {"fr"}})
+	assert.Equal(t, 8, hits)
+
+	// Test: Each representation is kept
+	get(t, transport, "http://example.com/v", http.Header{"Accept-Language": {"en"}})
+	get(t, transport, "http://example.com/v", http.Header{"Accept-Language": {"fr"}})
+	assert.Equal(t, 8, hits)
+
+	// Test: An unsafe request invalidates every representation
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/v", nil)
+	require.NoError(t, err)
+	_, err = transport.RoundTrip(req)
+	require.NoError(t, err)
+	hits = 0
+	get(t, transport, "http://example.com/v", http.Header{"Accept-Language": {"en"}})
+	get(t, transport, "http://example.com/v", http.Header{"Accept-Language": {"fr"}})
+	assert.Equal(t, 2, hits)
+
+	// Test: Responses to Range requests
+	vary = ""
+	hits = 0
+	get(t, transport, "http://example.com/range", http.Header{"Range": {"bytes=0-1"}})
+	get(t, transport, "http://example.com/range", http.Header{"Range": {"bytes=0-1"}})
+	assert.Equal(t, 2, hits)
+}
+
+func TestTransportPartialContent(t *testing.T) {
+	hits := 0
+	origin := originFunc(func(req *http.Request) *http.Response {
+		hits++
+		return originResponse(http.StatusPartialContent, http.Header{
+			"Cache-Control": {"max-age=60"},
+			"Content-Range": {"bytes 0-1/5"},
+		}, "he")
+	})
+	transport := &Transport{Store: NewMemoryStore(1 << 20), Next: origin}
+
+	// Test: A 206 is not stored, even with an explicit lifetime
+	get(t, transport, "http://example.com/", nil)
+	res, body := get(t, transport, "http://example.com/", nil)
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, "he", body)
+	assert.Equal(t, 2, hits)
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	store := NewMemoryStore(10)
+	store.Set("a", &Entry{Body: []byte("aaaa")})
+	store.Set("b", &Entry{Body: []byte("bbbb")})
+	_, ok := store.Get("a")
+	require.True(t, ok)
+
+	// Test: Least recently used entry is evicted
+	store.Set("c", &Entry{Body: []byte("cccc")})
+	_, ok = store.Get("b")
+	assert.False(t, ok)
+	_, ok = store.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, store.Len())
+
+	// Test: Oversized entries are not stored
+	store.Set("d", &Entry{Body: []byte("this is far too long")})
+	_, ok = store.Get("d")
+	assert.False(t, ok)
+}
+
+func TestDiskStore(t *testing.T) {
+	store, err := NewDiskStore(t.TempDir())
+	require.NoError(t, err)
+
+	_, ok := store.Get("http://example.com/")
+	assert.False(t, ok)
+
+	store.Set("http://example.com/", &Entry{
+		StatusCode: 200,
+		Header:     http.Header{"Etag": {`"v1"`}},
+		Body:       []byte("hello"),
+	})
+	entry, ok := store.Get("http://example.com/")
+	require.True(t, ok)
+	assert.Equal(t, 200, entry.StatusCode)
+	assert.Equal(t, `"v1"`, entry.Header.Get("ETag"))
+	assert.Equal(t, "hello", string(entry.Body))
+
+	store.Delete("http://example.com/")
+	_, ok = store.Get("http://example.com/")
+	assert.False(t, ok)
+}
@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MemoryStore is an in-memory Store that evicts the least recently used
+// entries once the stored bodies exceed its size limit.
+type MemoryStore struct {
+	mu       sync.Mutex
+	maxBytes int
+	size     int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+}
+
+// NewMemoryStore creates a MemoryStore holding at most maxBytes of bodies.
+func NewMemoryStore(maxBytes int) *MemoryStore {
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (m *MemoryStore) Get(key string) (*Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	m.order.MoveToFront(elem)
+	return elem.Value.(*memoryItem).entry, true
+}
+
+func (m *MemoryStore) Set(key string, entry *Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(entry.Body) > m.maxBytes {
+		return
+	}
+	if elem, ok := m.entries[key]; ok {
+		m.removeElement(elem)
+	}
+	m.entries[key] = m.order.PushFront(&memoryItem{key: key, entry: entry})
+	m.size += len(entry.Body)
+	for m.size > m.maxBytes {
+		m.removeElement(m.order.Back())
+	}
+}
+
+func (m *MemoryStore) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if elem, ok := m.entries[key]; ok {
+		m.removeElement(elem)
+	}
+}
+
+// Len returns the number of stored entries.
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+func (m *MemoryStore) removeElement(elem *list.Element) {
+	item := elem.Value.(*memoryItem)
+	m.order.Remove(elem)
+	delete(m.entries, item.key)
+	m.size -= len(item.entry.Body)
+}
+
+// DiskStore is a Store that keeps each entry in its own file under a directory.
+type DiskStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewDiskStore creates a DiskStore in dir, creating the directory if needed.
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DiskStore{dir: dir}, nil
+}
+
+func (d *DiskStore) Get(key string) (*Entry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := os.Open(d.path(key))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Error opening cache entry: %v", err)
+		}
+		return nil, false
+	}
+	defer f.Close()
+
+	var stored diskEntry
+	if err := gob.NewDecoder(f).Decode(&stored); err != nil || stored.Key != key {
+		return nil, false
+	}
+	return &stored.Entry, true
+}
+
+func (d *DiskStore) Set(key string, entry *Entry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Write to a temporary file first so readers never see a partial entry.
+	tmp, err := os.CreateTemp(d.dir, "entry-*.tmp")
+	if err != nil {
+		log.Printf("Error creating cache entry: %v", err)
+		return
+	}
+	err = gob.NewEncoder(tmp).Encode(diskEntry{Key: key, Entry: *entry})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.path(key))
+	}
+	if err != nil {
+		log.Printf("Error writing cache entry: %v", err)
+		os.Remove(tmp.Name())
+	}
+}
+
+func (d *DiskStore) Delete(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.Remove(d.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Error deleting cache entry: %v", err)
+	}
+}
+
+// diskEntry keeps the key next to the entry to guard against hash collisions.
+type diskEntry struct {
+	Key   string
+	Entry Entry
+}
+
+func (d *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,202 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/headers"
+)
+
+// SameSite is the SameSite attribute of a cookie.
+type SameSite int
+
+const (
+	// SameSiteDefault omits the attribute, leaving the browser's default.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	// SameSiteNone requires Secure.
+	SameSiteNone
+)
+
+// expiresFormat is the IMF-fixdate format of RFC 9110 section 5.6.7.
+const expiresFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// ErrInvalidCookie is returned for a cookie that cannot be sent as a valid
+// Set-Cookie header.
+var ErrInvalidCookie = errors.New("invalid cookie")
+
+// Cookie is a cookie sent by a client, or one to set with Set.
+type Cookie struct {
+	Name  string
+	Value string
+
+	// The attributes below only apply to Set-Cookie.
+
+	// Expires is omitted when zero.
+	Expires time.Time
+	// MaxAge is in seconds. Zero omits it and a negative value deletes the
+	// cookie by sending "Max-Age=0".
+	MaxAge   int
+	Domain   string
+	Path     string
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+	// Partitioned stores the cookie per top-level site (CHIPS). It requires
+	// Secure.
+	Partitioned bool
+}
+
+// Parse parses the value of a Cookie header. Pairs that are not valid are
+// skipped, and surrounding double quotes are removed from values.
+func Parse(header string) []Cookie {
+	var cookies []Cookie
+	for _, pair := range strings.Split(header, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !headers.IsToken(name) {
+			continue
+		}
+		value, ok = unquote(value)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// Get returns the value of the first cookie called name in a Cookie header.
+func Get(header, name string) (string, bool) {
+	for _, c := range Parse(header) {
+		if c.Name == name {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set adds c to h as a Set-Cookie header, keeping any cookies already set.
+func Set(h headers.Headers, c *Cookie) error {
+	value, err := c.String()
+	if err != nil {
+		return err
+	}
+	h.Add("Set-Cookie", value)
+	return nil
+}
+
+// String returns c as the value of a Set-Cookie header.
+func (c *Cookie) String() (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name + "=" + c.Value)
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(expiresFormat))
+	}
+	switch {
+	case c.MaxAge > 0:
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	case c.MaxAge < 0:
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String(), nil
+}
+
+// Validate checks c against the Set-Cookie grammar of RFC 6265 section 4.1.
+func (c *Cookie) Validate() error {
+	if !headers.IsToken(c.Name) {
+		return fmt.Errorf("%w: name %q", ErrInvalidCookie, c.Name)
+	}
+	if _, ok := unquote(c.Value); !ok {
+		return fmt.Errorf("%w: value of %s", ErrInvalidCookie, c.Name)
+	}
+	if !isAttributeValue(c.Path) {
+		return fmt.Errorf("%w: path %q", ErrInvalidCookie, c.Path)
+	}
+	if c.Domain != "" && !isDomain(strings.TrimPrefix(c.Domain, ".")) {
+		return fmt.Errorf("%w: domain %q", ErrInvalidCookie, c.Domain)
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("%w: expiry before 1601", ErrInvalidCookie)
+	}
+	if (c.SameSite == SameSiteNone || c.Partitioned) && !c.Secure {
+		return fmt.Errorf("%w: SameSite=None and Partitioned require Secure", ErrInvalidCookie)
+	}
+	return nil
+}
+
+// unquote checks that value is made of cookie-octets, optionally inside
+// double quotes, and returns it without the quotes.
+func unquote(value string) (string, bool) {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x21 || c > 0x7e || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+// isAttributeValue reports whether v can be an attribute value: any
+// printable character except ";".
+func isAttributeValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if v[i] < 0x20 || v[i] > 0x7e || v[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+// isDomain reports whether d is a host name made of letters, digits, hyphens
+// and dots, with no empty labels.
+func isDomain(d string) bool {
+	if d == "" || len(d) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(d, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Pairs, quoted values and invalid pairs
+	cookies := Parse(`session=abc123; theme="dark";  lang=en-GB; bad name=1; novalue; empty=; spaced=a b`)
+	assert.Equal(t, []Cookie{
+		{Name: "session", Value: "abc123"},
+		{Name: "theme", Value: "dark"},
+		{Name: "lang", Value: "en-GB"},
+		{Name: "empty", Value: ""},
+	}, cookies)
+
+	// Test: Get returns the first match
+	value, ok := Get("a=1; b=2; a=3", "a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", value)
+	_, ok = Get("a=1", "b")
+	assert.False(t, ok)
+
+	// Test: Empty header
+	assert.Empty(t, Parse(""))
+}
+
+func TestString(t *testing.T) {
+	// Test: Every attribute
+	c := &Cookie{
+		Name:        "id",
+		Value:       "a3fWa",
+		Expires:     time.Date(2026, 10, 21, 9, 28, 0, 0, time.FixedZone("CEST", 2*60*60)),
+		MaxAge:      2592000,
+		Domain:      ".example.com",
+		Path:        "/docs",
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	value, err := c.String()
+	require.NoError(t, err)
+	assert.Equal(t, "id=a3fWa; Path=/docs; Domain=example.com; Expires=Wed, 21 Oct 2026 07:28:00 GMT; Max-Age=2592000; Secure; HttpOnly; SameSite=None; Partitioned", value)
+
+	// Test: Deleting a cookie
+	value, err = (&Cookie{Name: "id", MaxAge: -1, SameSite: SameSiteLax}).String()
+	require.NoError(t, err)
+	assert.Equal(t, "id=; Max-Age=0; SameSite=Lax", value)
+
+	// Test: Invalid cookies
+	for name, c := range map[string]*Cookie{
+		"empty name":               {Value: "x"},
+		"separator in name":        {Name: "a;b", Value: "x"},
+		"space in value":           {Name: "a", Value: "x y"},
+		"comma in value":           {Name: "a", Value: "x,y"},
+		"semicolon in path":        {Name: "a", Path: "/;x"},
+		"bad domain":               {Name: "a", Domain: "exa mple.com"},
+		"empty label":              {Name: "a", Domain: "example..com"},
+		"SameSite=None not Secure": {Name: "a", SameSite: SameSiteNone},
+		"Partitioned not Secure":   {Name: "a", Partitioned: true},
+		"expiry before 1601":       {Name: "a", Expires: time.Date(1500, 1, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		_, err := c.String()
+		assert.ErrorIs(t, err, ErrInvalidCookie, name)
+	}
+}
+
+func TestSet(t *testing.T) {
+	// Test: Several cookies on one set of headers
+	h := headers.Headers{"Content-Type": "text/plain"}
+	require.NoError(t, Set(h, &Cookie{Name: "a", Value: "1", HttpOnly: true}))
+	require.NoError(t, Set(h, &Cookie{Name: "b", Value: "2"}))
+	assert.Equal(t, []string{"a=1; HttpOnly", "b=2"}, h.Values("Set-Cookie"))
+
+	// Test: An invalid cookie leaves the headers alone
+	require.Error(t, Set(h, &Cookie{Name: "c", Value: "\x00"}))
+	assert.Len(t, h.Values("Set-Cookie"), 2)
+}
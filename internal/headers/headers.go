@@ -1,7 +1,9 @@
 package headers
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -13,7 +15,23 @@ func NewHeaders() Headers {
 	return make(Headers)
 }
 
+// Parse parses a block of header fields, accepting what older peers send:
+// obsolete line folding is replaced by a space and control characters other
+// than NUL are allowed in values.
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.parse(data, false)
+}
+
+// ParseStrict parses like Parse but follows RFC 9112 strictly: obsolete line
+// folding and control characters in values are errors.
+func (h Headers) ParseStrict(data []byte) (n int, done bool, err error) {
+	return h.parse(data, true)
+}
+
+func (h Headers) parse(data []byte, strict bool) (n int, done bool, err error) {
+	parsed := make(Headers)
+	// lastKey is the field an obs-fold line continues.
+	lastKey := ""
 	// Convert data to string for easier manipulation
 	dataStr := string(data)
 
@@ -33,16 +51,36 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 			done = true
 			break
 		}
+		// A lone CR or LF could end the line early for another parser.
+		if strings.ContainsAny(headerLine, "\r\n") {
+			return 0, false, fmt.Errorf("bare CR or LF in header line: %q", headerLine)
+		}
+		if headerLine[0] == ' ' || headerLine[0] == '\t' {
+			// Obsolete line folding (RFC 9112 section 5.2). Leading
+			// whitespace before the first field is never valid.
+			if strict || lastKey == "" {
+				return 0, false, fmt.Errorf("obsolete line folding: %q", headerLine)
+			}
+			if value := strings.TrimSpace(headerLine); value != "" {
+				if err := checkFieldValue(value, strict); err != nil {
+					return 0, false, err
+				}
+				parsed[lastKey] += " " + value
+			}
+			continue
+		}
 		// Split the header line into key and value
 		colonIndex := strings.Index(headerLine, ":")
-		if colonIndex == -1 {
+		if colonIndex <= 0 {
 			return 0, false, fmt.Errorf("invalid header line: %s", headerLine)
 		}
 		if headerLine[colonIndex-1] == ' ' {
 			return 0, false, fmt.Errorf("invalid header line: %s", headerLine)
 		}
 
-		key := strings.TrimSpace(strings.ToLower(headerLine[:colonIndex]))
+		// Whitespace around the name is not trimmed: "Host : x" and a line
+		// starting with whitespace are rejected as invalid names.
+		key := strings.ToLower(headerLine[:colonIndex])
 		value := strings.TrimSpace(headerLine[colonIndex+1:])
 
 		if !isValidHeaderFieldName(key) {
@@ -52,26 +90,149 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		if key == "" || value == "" {
 			return 0, false, fmt.Errorf("invalid header line: %s", headerLine)
 		}
+		if err := checkFieldValue(value, strict); err != nil {
+			return 0, false, err
+		}
 
-		if _, exists := h[key]; exists {
+		if _, exists := parsed[key]; exists {
 			// If the header already exists, append the new value
-			h[key] += ", " + value
+			parsed[key] += separator(key) + value
 		} else {
 			// If the header does not exist, add it to the map
-			h[key] = value
+			parsed[key] = value
 		}
+		lastKey = key
 	}
 
+	// Fields are only added once the block is complete, as a fold may
+	// still change the last one.
+	for key, value := range parsed {
+		if _, exists := h[key]; exists {
+			h[key] += separator(key) + value
+		} else {
+			h[key] = value
+		}
+	}
 	return n - len(CRLF), true, nil
 }
 
+// checkFieldValue rejects characters a field value must not contain
+// (RFC 9110 section 5.5). NUL is always rejected; in strict mode so is every
+// other control character except horizontal tab.
+func checkFieldValue(value string, strict bool) error {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == 0 || strict && (c < ' ' && c != '\t' || c == 0x7f) {
+			return fmt.Errorf("invalid character %q in header value", c)
+		}
+	}
+	return nil
+}
+
+// IsToken reports whether s is a token (RFC 9110 section 5.6.2), the grammar
+// shared by field names and request methods.
+func IsToken(s string) bool {
+	return isValidHeaderFieldName(s)
+}
+
+// HasToken reports whether the comma-separated field value contains token,
+// compared case-insensitively, as in "Connection: keep-alive, Upgrade".
+func HasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseChunkSize parses the chunk-size line of a chunked body, ignoring any
+// chunk extensions. The size must be bare hex digits: signs, prefixes and
+// leading whitespace are all ways to make two parsers disagree on where the
+// chunk ends.
+func ParseChunkSize(line string) (int, error) {
+	if strings.ContainsAny(line, "\r\n") {
+		return 0, errors.New("bare CR or LF in chunk size line")
+	}
+	if i := strings.Index(line, ";"); i != -1 {
+		line = strings.TrimRight(line[:i], " \t")
+	}
+	if line == "" || strings.Trim(line, "0123456789abcdefABCDEF") != "" {
+		return 0, errors.New("invalid chunk size")
+	}
+	size, err := strconv.ParseInt(line, 16, 32)
+	if err != nil || size < 0 {
+		return 0, errors.New("invalid chunk size")
+	}
+	return int(size), nil
+}
+
 func (h Headers) Get(key string) string {
 	if value, exists := h[strings.ToLower(key)]; exists {
 		return value
 	}
+	// Headers built for responses keep their canonical casing, so fall back
+	// to a case-insensitive scan.
+	for k, value := range h {
+		if strings.EqualFold(k, key) {
+			return value
+		}
+	}
 	return ""
 }
 
+// Add appends value to any existing value for key, whatever its casing.
+func (h Headers) Add(key, value string) {
+	existing := h.Get(key)
+	if existing == "" {
+		h.Set(key, value)
+		return
+	}
+	h.Set(key, existing+separator(key)+value)
+}
+
+// Values returns the values of key. Set-Cookie lines cannot be combined, so
+// each is returned separately; any other field has a single combined value.
+func (h Headers) Values(key string) []string {
+	value := h.Get(key)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, LineSeparator)
+}
+
+// LineSeparator separates the values of a field that must be sent as
+// separate lines. No field value can contain it.
+const LineSeparator = "\n"
+
+// separator is what joins the values of repeated key fields: a comma for
+// list-based fields, "; " for Cookie (RFC 9113 section 8.2.3), and
+// LineSeparator for Set-Cookie (RFC 9110 section 5.3).
+func separator(key string) string {
+	switch strings.ToLower(key) {
+	case "set-cookie":
+		return LineSeparator
+	case "cookie":
+		return "; "
+	}
+	return ", "
+}
+
+// Set replaces any existing value for key, whatever its casing, with value.
+func (h Headers) Set(key, value string) {
+	h.Del(key)
+	h[key] = value
+}
+
+// Del removes every entry whose name matches key case-insensitively.
+func (h Headers) Del(key string) {
+	for k := range h {
+		if strings.EqualFold(k, key) {
+			delete(h, k)
+		}
+	}
+}
+
 func isValidHeaderFieldName(name string) bool {
 	if len(name) == 0 {
 		return false
@@ -77,4 +77,100 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, "lane-loves-go, Bobs-your-uncle, Good Course", headers["set-person"])
 	assert.Equal(t, 104, n)
 	assert.True(t, done)
+
+	// Test: Line without a field name
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte(": value\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Bare LF inside a header line
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("Host: a\nX-Other: b\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Leading whitespace before the name
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("\tHost: a\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Control character in a value is only an error in ParseStrict
+	headers = NewHeaders()
+	_, _, err = headers.ParseStrict([]byte("X-Test: a\x1bb\r\n\r\n"))
+	require.Error(t, err)
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X-Test: a\x1bb\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "a\x1bb", headers["x-test"])
+
+	// Test: Obsolete line folding is an error in ParseStrict and unfolded by Parse
+	data = []byte("X-Test: one\r\n  two\r\nHost: a\r\n\r\n")
+	headers = NewHeaders()
+	_, _, err = headers.ParseStrict(data)
+	require.Error(t, err)
+	headers = NewHeaders()
+	n, done, err = headers.Parse(data)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, len(data)-2, n)
+	assert.Equal(t, "one two", headers["x-test"])
+}
+
+func TestHeadersSetDel(t *testing.T) {
+	// Test: Get falls back to a case-insensitive lookup
+	headers := Headers{"Content-Type": "text/plain"}
+	assert.Equal(t, "text/plain", headers.Get("content-type"))
+
+	// Test: Set replaces differently cased keys
+	headers.Set("content-type", "text/html")
+	assert.Equal(t, Headers{"content-type": "text/html"}, headers)
+
+	// Test: Del removes every casing
+	headers = Headers{"Trailer": "a", "trailer": "b", "Host": "localhost"}
+	headers.Del("TRAILER")
+	assert.Equal(t, Headers{"Host": "localhost"}, headers)
+}
+
+func TestHeadersAddValues(t *testing.T) {
+	// Test: List fields are joined with commas
+	headers := Headers{"Vary": "Accept"}
+	headers.Add("vary", "Accept-Encoding")
+	assert.Equal(t, Headers{"vary": "Accept, Accept-Encoding"}, headers)
+	assert.Equal(t, []string{"Accept, Accept-Encoding"}, headers.Values("Vary"))
+
+	// Test: Set-Cookie values stay separate
+	headers = NewHeaders()
+	headers.Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+	headers.Add("Set-Cookie", "b=2")
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"}, headers.Values("set-cookie"))
+	assert.Nil(t, headers.Values("Cookie"))
+
+	// Test: Parsed Set-Cookie and Cookie lines
+	headers = NewHeaders()
+	_, _, err := headers.Parse([]byte("Set-Cookie: a=1\r\nSet-Cookie: b=2\r\nCookie: c=3\r\nCookie: d=4\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1", "b=2"}, headers.Values("Set-Cookie"))
+	assert.Equal(t, "c=3; d=4", headers.Get("Cookie"))
+}
+
+func TestHasToken(t *testing.T) {
+	// Test: Tokens are matched case-insensitively, ignoring whitespace
+	assert.True(t, HasToken("keep-alive, Upgrade", "upgrade"))
+	assert.True(t, HasToken("websocket", "WebSocket"))
+	assert.False(t, HasToken("upgraded", "upgrade"))
+	assert.False(t, HasToken("", "upgrade"))
+}
+
+func TestParseChunkSize(t *testing.T) {
+	// Test: Hex sizes, with and without extensions
+	for line, want := range map[string]int{"0": 0, "a": 10, "1F": 31, "5;name=value": 5, "5 ;ext": 5} {
+		size, err := ParseChunkSize(line)
+		require.NoError(t, err, line)
+		assert.Equal(t, want, size, line)
+	}
+
+	// Test: Anything but bare hex digits is rejected
+	for _, line := range []string{"", "+5", "-5", " 5", "5 ", "0x5", "5\r", "zz", "fffffffff"} {
+		_, err := ParseChunkSize(line)
+		assert.Error(t, err, line)
+	}
 }
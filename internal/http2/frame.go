@@ -0,0 +1,218 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FrameType identifies the kind of a frame, RFC 9113 section 6.
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+// Frame flags. Their meaning depends on the frame type.
+const (
+	FlagEndStream  uint8 = 0x1
+	FlagAck        uint8 = 0x1
+	FlagEndHeaders uint8 = 0x4
+	FlagPadded     uint8 = 0x8
+	FlagPriority   uint8 = 0x20
+)
+
+// ErrCode is the error code carried by RST_STREAM and GOAWAY frames.
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+// SettingID identifies a SETTINGS parameter.
+type SettingID uint16
+
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+const (
+	frameHeaderLen     = 9
+	defaultFrameSize   = 16384
+	maxFrameSizeLimit  = 1<<24 - 1
+	defaultWindowSize  = 65535
+	maxWindowSize      = 1<<31 - 1
+	defaultTableSize   = 4096
+	streamIDMask       = 1<<31 - 1
+	settingEntryLength = 6
+)
+
+// Preface is the connection preface every client sends first.
+const Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// Frame is a single frame with its payload still encoded.
+type Frame struct {
+	Type     FrameType
+	Flags    uint8
+	StreamID uint32
+	Payload  []byte
+}
+
+// Has reports whether flag is set on the frame.
+func (f *Frame) Has(flag uint8) bool {
+	return f.Flags&flag != 0
+}
+
+// ConnError is a connection error: the connection is ended with GOAWAY.
+type ConnError struct {
+	Code   ErrCode
+	Reason string
+}
+
+func (e ConnError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.Code, e.Reason)
+}
+
+// StreamError is a stream error: only the stream is reset.
+type StreamError struct {
+	StreamID uint32
+	Code     ErrCode
+	Reason   string
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error %d: %s", e.StreamID, e.Code, e.Reason)
+}
+
+// ReadFrame reads one frame, refusing payloads larger than maxSize.
+func ReadFrame(r io.Reader, maxSize uint32) (*Frame, error) {
+	var header [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+	f := &Frame{
+		Type:     FrameType(header[3]),
+		Flags:    header[4],
+		StreamID: binary.BigEndian.Uint32(header[5:]) & streamIDMask,
+	}
+	if length > maxSize {
+		return nil, ConnError{ErrCodeFrameSize, fmt.Sprintf("frame of %d bytes exceeds %d", length, maxSize)}
+	}
+	f.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// WriteFrame writes f with a single Write call.
+func WriteFrame(w io.Writer, f *Frame) error {
+	buf := make([]byte, frameHeaderLen, frameHeaderLen+len(f.Payload))
+	buf[0] = byte(len(f.Payload) >> 16)
+	buf[1] = byte(len(f.Payload) >> 8)
+	buf[2] = byte(len(f.Payload))
+	buf[3] = byte(f.Type)
+	buf[4] = f.Flags
+	binary.BigEndian.PutUint32(buf[5:], f.StreamID&streamIDMask)
+	_, err := w.Write(append(buf, f.Payload...))
+	return err
+}
+
+// removePadding strips the padding of a PADDED DATA or HEADERS frame.
+func removePadding(f *Frame) ([]byte, error) {
+	payload := f.Payload
+	if !f.Has(FlagPadded) {
+		return payload, nil
+	}
+	if len(payload) == 0 {
+		return nil, ConnError{ErrCodeProtocol, "padded frame without pad length"}
+	}
+	padLength := int(payload[0])
+	payload = payload[1:]
+	if padLength > len(payload) {
+		return nil, ConnError{ErrCodeProtocol, "padding longer than payload"}
+	}
+	return payload[:len(payload)-padLength], nil
+}
+
+// Setting is one SETTINGS parameter.
+type Setting struct {
+	ID    SettingID
+	Value uint32
+}
+
+func parseSettings(payload []byte) ([]Setting, error) {
+	if len(payload)%settingEntryLength != 0 {
+		return nil, ConnError{ErrCodeFrameSize, "SETTINGS length not a multiple of 6"}
+	}
+	settings := make([]Setting, 0, len(payload)/settingEntryLength)
+	for i := 0; i < len(payload); i += settingEntryLength {
+		settings = append(settings, Setting{
+			ID:    SettingID(binary.BigEndian.Uint16(payload[i:])),
+			Value: binary.BigEndian.Uint32(payload[i+2:]),
+		})
+	}
+	return settings, nil
+}
+
+func settingsFrame(settings ...Setting) *Frame {
+	payload := make([]byte, 0, len(settings)*settingEntryLength)
+	for _, s := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(s.ID))
+		payload = binary.BigEndian.AppendUint32(payload, s.Value)
+	}
+	return &Frame{Type: FrameSettings, Payload: payload}
+}
+
+func windowUpdateFrame(streamID, increment uint32) *Frame {
+	return &Frame{
+		Type:     FrameWindowUpdate,
+		StreamID: streamID,
+		Payload:  binary.BigEndian.AppendUint32(nil, increment),
+	}
+}
+
+func rstStreamFrame(streamID uint32, code ErrCode) *Frame {
+	return &Frame{
+		Type:     FrameRSTStream,
+		StreamID: streamID,
+		Payload:  binary.BigEndian.AppendUint32(nil, uint32(code)),
+	}
+}
+
+func goAwayFrame(lastStreamID uint32, code ErrCode, debug string) *Frame {
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	return &Frame{Type: FrameGoAway, Payload: append(payload, debug...)}
+}
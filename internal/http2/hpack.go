@@ -0,0 +1,352 @@
+package http2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HeaderField is a single name-value pair of a header block.
+type HeaderField struct {
+	Name, Value string
+	// Sensitive fields are never added to a compression table.
+	Sensitive bool
+}
+
+// size is the size of the field as counted against a dynamic table.
+func (f HeaderField) size() uint32 {
+	return uint32(len(f.Name) + len(f.Value) + 32)
+}
+
+var errCompression = errors.New("hpack: invalid header block")
+
+// staticTable is the static table of RFC 7541 Appendix A. Index 1 is the first entry.
+var staticTable = []HeaderField{
+	{Name: ":authority"},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":path", Value: "/"},
+	{Name: ":path", Value: "/index.html"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-charset"},
+	{Name: "accept-encoding", Value: "gzip, deflate"},
+	{Name: "accept-language"},
+	{Name: "accept-ranges"},
+	{Name: "accept"},
+	{Name: "access-control-allow-origin"},
+	{Name: "age"},
+	{Name: "allow"},
+	{Name: "authorization"},
+	{Name: "cache-control"},
+	{Name: "content-disposition"},
+	{Name: "content-encoding"},
+	{Name: "content-language"},
+	{Name: "content-length"},
+	{Name: "content-location"},
+	{Name: "content-range"},
+	{Name: "content-type"},
+	{Name: "cookie"},
+	{Name: "date"},
+	{Name: "etag"},
+	{Name: "expect"},
+	{Name: "expires"},
+	{Name: "from"},
+	{Name: "host"},
+	{Name: "if-match"},
+	{Name: "if-modified-since"},
+	{Name: "if-none-match"},
+	{Name: "if-range"},
+	{Name: "if-unmodified-since"},
+	{Name: "last-modified"},
+	{Name: "link"},
+	{Name: "location"},
+	{Name: "max-forwards"},
+	{Name: "proxy-authenticate"},
+	{Name: "proxy-authorization"},
+	{Name: "range"},
+	{Name: "referer"},
+	{Name: "refresh"},
+	{Name: "retry-after"},
+	{Name: "server"},
+	{Name: "set-cookie"},
+	{Name: "strict-transport-security"},
+	{Name: "transfer-encoding"},
+	{Name: "user-agent"},
+	{Name: "vary"},
+	{Name: "via"},
+	{Name: "www-authenticate"},
+}
+
+// staticIndex finds f in the static table. It returns the index of an exact
+// match if there is one, otherwise the index of the first entry with the
+// same name, and 0 if the name is not in the table.
+func staticIndex(f HeaderField) (index int, exact bool) {
+	for i, entry := range staticTable {
+		if entry.Name != f.Name {
+			continue
+		}
+		if entry.Value == f.Value {
+			return i + 1, true
+		}
+		if index == 0 {
+			index = i + 1
+		}
+	}
+	return index, false
+}
+
+// dynamicTable is the FIFO table of RFC 7541 section 2.3.2. The newest entry
+// is last in entries but has the lowest index.
+type dynamicTable struct {
+	entries []HeaderField
+	size    uint32
+	maxSize uint32
+}
+
+func (t *dynamicTable) add(f HeaderField) {
+	t.entries = append(t.entries, f)
+	t.size += f.size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(n uint32) {
+	t.maxSize = n
+	t.evict()
+}
+
+func (t *dynamicTable) evict() {
+	drop := 0
+	for t.size > t.maxSize && drop < len(t.entries) {
+		t.size -= t.entries[drop].size()
+		drop++
+	}
+	t.entries = t.entries[drop:]
+}
+
+// Decoder decompresses header blocks. A connection uses one Decoder for all
+// blocks it receives, in order, since they share the dynamic table.
+type Decoder struct {
+	table dynamicTable
+	// limit is the largest table size the peer may ask for.
+	limit uint32
+	// MaxStringLength bounds each decoded name or value.
+	MaxStringLength int
+}
+
+// NewDecoder returns a decoder whose dynamic table may grow to maxTableSize bytes.
+func NewDecoder(maxTableSize uint32) *Decoder {
+	return &Decoder{
+		table:           dynamicTable{maxSize: maxTableSize},
+		limit:           maxTableSize,
+		MaxStringLength: 16 << 10,
+	}
+}
+
+// Decode decodes a complete header block.
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	var fields []HeaderField
+	seenField := false
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0: // Indexed header field
+			index, rest, err := readInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			f, err := d.at(index)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+			block = rest
+		case b&0xC0 == 0x40: // Literal with incremental indexing
+			f, rest, err := d.readLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+			fields = append(fields, f)
+			block = rest
+		case b&0xE0 == 0x20: // Dynamic table size update
+			// Updates are only allowed before the first field of a block.
+			if seenField {
+				return nil, fmt.Errorf("%w: table size update after a field", errCompression)
+			}
+			size, rest, err := readInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.limit) {
+				return nil, fmt.Errorf("%w: table size %d over limit", errCompression, size)
+			}
+			d.table.setMaxSize(uint32(size))
+			block = rest
+			continue
+		default: // Literal without indexing (0000) or never indexed (0001)
+			f, rest, err := d.readLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+			f.Sensitive = b&0x10 != 0
+			fields = append(fields, f)
+			block = rest
+		}
+		seenField = true
+	}
+	return fields, nil
+}
+
+// at looks up an index in the combined static and dynamic address space.
+func (d *Decoder) at(index uint64) (HeaderField, error) {
+	if index == 0 {
+		return HeaderField{}, fmt.Errorf("%w: index 0", errCompression)
+	}
+	if index <= uint64(len(staticTable)) {
+		return staticTable[index-1], nil
+	}
+	i := index - uint64(len(staticTable))
+	if i > uint64(len(d.table.entries)) {
+		return HeaderField{}, fmt.Errorf("%w: index %d out of range", errCompression, index)
+	}
+	return d.table.entries[uint64(len(d.table.entries))-i], nil
+}
+
+// readLiteral reads a literal field whose name index has an n-bit prefix.
+func (d *Decoder) readLiteral(block []byte, n uint8) (HeaderField, []byte, error) {
+	index, rest, err := readInt(block, n)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+	var f HeaderField
+	if index == 0 {
+		if f.Name, rest, err = d.readString(rest); err != nil {
+			return HeaderField{}, nil, err
+		}
+	} else {
+		named, err := d.at(index)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+		f.Name = named.Name
+	}
+	if f.Value, rest, err = d.readString(rest); err != nil {
+		return HeaderField{}, nil, err
+	}
+	return f, rest, nil
+}
+
+// readString reads a string literal, which may be Huffman-encoded.
+func (d *Decoder) readString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, fmt.Errorf("%w: truncated string", errCompression)
+	}
+	huffman := block[0]&0x80 != 0
+	length, rest, err := readInt(block, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if length > uint64(len(rest)) {
+		return "", nil, fmt.Errorf("%w: truncated string", errCompression)
+	}
+	if d.MaxStringLength > 0 && length > uint64(d.MaxStringLength) {
+		return "", nil, fmt.Errorf("%w: string too long", errCompression)
+	}
+	raw := rest[:length]
+	rest = rest[length:]
+	if !huffman {
+		return string(raw), rest, nil
+	}
+	decoded, err := huffmanDecode(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	if d.MaxStringLength > 0 && len(decoded) > d.MaxStringLength {
+		return "", nil, fmt.Errorf("%w: string too long", errCompression)
+	}
+	return string(decoded), rest, nil
+}
+
+// readInt reads an integer with an n-bit prefix, as in RFC 7541 section 5.1.
+func readInt(block []byte, n uint8) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, fmt.Errorf("%w: truncated integer", errCompression)
+	}
+	max := uint64(1)<<n - 1
+	value := uint64(block[0]) & max
+	block = block[1:]
+	if value < max {
+		return value, block, nil
+	}
+	var shift uint
+	for i, b := range block {
+		if shift > 56 {
+			return 0, nil, fmt.Errorf("%w: integer overflow", errCompression)
+		}
+		value += uint64(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return value, block[i+1:], nil
+		}
+		shift += 7
+	}
+	return 0, nil, fmt.Errorf("%w: truncated integer", errCompression)
+}
+
+// appendInt appends value with an n-bit prefix; first holds the flag bits
+// that share the prefix byte.
+func appendInt(dst []byte, first byte, n uint8, value uint64) []byte {
+	max := uint64(1)<<n - 1
+	if value < max {
+		return append(dst, first|byte(value))
+	}
+	dst = append(dst, first|byte(max))
+	value -= max
+	for value >= 0x80 {
+		dst = append(dst, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(dst, byte(value))
+}
+
+// appendString appends a string literal, Huffman-encoding it when that is shorter.
+func appendString(dst []byte, s string) []byte {
+	if n := huffmanEncodedLen(s); n < len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(n))
+		return appendHuffman(dst, s)
+	}
+	dst = appendInt(dst, 0, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// Encoder compresses header blocks. It only refers to the static table and
+// never adds entries to the peer's dynamic table, so it needs no state and
+// is unaffected by SETTINGS_HEADER_TABLE_SIZE.
+type Encoder struct{}
+
+// Encode appends the header block for fields to dst.
+func (Encoder) Encode(dst []byte, fields []HeaderField) []byte {
+	for _, f := range fields {
+		index, exact := staticIndex(f)
+		if exact {
+			dst = appendInt(dst, 0x80, 7, uint64(index))
+			continue
+		}
+		first := byte(0x00) // Literal without indexing
+		if f.Sensitive {
+			first = 0x10 // Literal never indexed
+		}
+		dst = appendInt(dst, first, 4, uint64(index))
+		if index == 0 {
+			dst = appendString(dst, f.Name)
+		}
+		dst = appendString(dst, f.Value)
+	}
+	return dst
+}
@@ -0,0 +1,103 @@
+package http2
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+func TestIntegers(t *testing.T) {
+	// Test: Examples from RFC 7541 appendix C.1
+	assert.Equal(t, []byte{0x0a}, appendInt(nil, 0, 5, 10))
+	assert.Equal(t, []byte{0x1f, 0x9a, 0x0a}, appendInt(nil, 0, 5, 1337))
+	assert.Equal(t, []byte{0x2a}, appendInt(nil, 0, 8, 42))
+
+	value, rest, err := readInt([]byte{0x1f, 0x9a, 0x0a, 0xff}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1337), value)
+	assert.Equal(t, []byte{0xff}, rest)
+
+	// Test: Truncated and overflowing integers
+	_, _, err = readInt([]byte{0x1f, 0x9a}, 5)
+	require.Error(t, err)
+	_, _, err = readInt(decodeHex(t, "1fffffffffffffffffffff01"), 5)
+	require.Error(t, err)
+}
+
+func TestDecoderRequests(t *testing.T) {
+	// Test: Huffman-coded requests from RFC 7541 appendix C.4 share a dynamic table
+	d := NewDecoder(defaultTableSize)
+	fields, err := d.Decode(decodeHex(t, "8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff"))
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"},
+		{Name: ":authority", Value: "www.example.com"},
+	}, fields)
+	assert.Equal(t, uint32(57), d.table.size)
+
+	fields, err = d.Decode(decodeHex(t, "8286 84be 5886 a8eb 1064 9cbf"))
+	require.NoError(t, err)
+	assert.Equal(t, HeaderField{Name: ":authority", Value: "www.example.com"}, fields[3])
+	assert.Equal(t, HeaderField{Name: "cache-control", Value: "no-cache"}, fields[4])
+	assert.Equal(t, uint32(110), d.table.size)
+
+	fields, err = d.Decode(decodeHex(t, "8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf"))
+	require.NoError(t, err)
+	assert.Equal(t, HeaderField{Name: ":path", Value: "/index.html"}, fields[2])
+	assert.Equal(t, HeaderField{Name: "custom-key", Value: "custom-value"}, fields[4])
+	assert.Equal(t, uint32(164), d.table.size)
+
+	// Test: Size updates shrink the table and may not exceed the limit
+	_, err = d.Decode([]byte{0x20})
+	require.NoError(t, err)
+	assert.Empty(t, d.table.entries)
+	_, err = d.Decode(appendInt(nil, 0x20, 5, defaultTableSize+1))
+	require.Error(t, err)
+
+	// Test: Index past the end of the tables
+	_, err = d.Decode([]byte{0xff, 0x00})
+	require.Error(t, err)
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	fields := []HeaderField{
+		{Name: ":status", Value: "200"},
+		{Name: "content-type", Value: "text/html"},
+		{Name: "x-custom", Value: "some value with CAPS and \x7f bytes"},
+		{Name: "authorization", Value: "secret", Sensitive: true},
+		{Name: "x-empty"},
+	}
+	block := Encoder{}.Encode(nil, fields)
+	assert.Equal(t, byte(0x88), block[0], ":status 200 is in the static table")
+
+	decoded, err := NewDecoder(defaultTableSize).Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, fields, decoded)
+}
+
+func TestHuffman(t *testing.T) {
+	// Test: "www.example.com" from RFC 7541 appendix C.4.1
+	encoded := appendHuffman(nil, "www.example.com")
+	assert.Equal(t, decodeHex(t, "f1e3 c2e5 f23a 6ba0 ab90 f4ff"), encoded)
+	decoded, err := huffmanDecode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, "www.example.com", string(decoded))
+
+	// Test: Padding must be all ones and shorter than a byte
+	_, err = huffmanDecode([]byte{0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xfe})
+	require.Error(t, err)
+	_, err = huffmanDecode([]byte{0xff, 0xff})
+	require.Error(t, err)
+}
@@ -0,0 +1,881 @@
+package http2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// Handler serves one request. It has the same shape as server.Handler.
+type Handler func(*response.Writer, *request.Request)
+
+const (
+	maxConcurrentStreams = 100
+	maxHeaderListSize    = 1 << 20
+	// initialWindowSize is the receive window advertised for each stream.
+	// Bodies are buffered, so the stream windows are replenished as data
+	// arrives; the connection window is held back by maxBufferedBodies.
+	initialWindowSize = 1 << 20
+	// MaxBodySize bounds a buffered request body; larger ones get a 413.
+	MaxBodySize = 10 << 20
+	// maxBufferedBodies bounds the request body bytes a connection holds for
+	// its streams. Past it, connection window credit is withheld until
+	// streams close.
+	maxBufferedBodies = 16 << 20
+)
+
+var errConnClosed = errors.New("http2: connection closed")
+
+type streamState int
+
+const (
+	stateOpen streamState = iota
+	// stateHalfClosedRemote: the request is complete, the response is not.
+	stateHalfClosedRemote
+	stateClosed
+)
+
+// stream is a single request/response exchange on a connection.
+type stream struct {
+	id    uint32
+	state streamState
+	// sendWindow is guarded by serverConn.mu.
+	sendWindow int64
+	recvWindow int64
+	// buffered counts the body bytes held against the connection's cap; it
+	// is guarded by serverConn.mu.
+	buffered int64
+
+	req      *request.Request
+	tooLarge bool
+	// gone is made by CloseNotify and closed, guarded by serverConn.mu, when
+	// the stream or the connection closes.
+	gone       chan struct{}
+	goneClosed bool
+}
+
+// markGone closes gone if anyone is waiting on it. sc.mu must be held.
+func (st *stream) markGone() {
+	if st.gone != nil && !st.goneClosed {
+		close(st.gone)
+		st.goneClosed = true
+	}
+}
+
+// serverConn is the server side of one HTTP/2 connection. A single goroutine
+// reads frames; each request runs its handler on its own goroutine.
+type serverConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	handler Handler
+
+	decoder *Decoder
+	encoder Encoder
+
+	// writeMu keeps frames, and the HEADERS and CONTINUATION frames of one
+	// header block, from interleaving.
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// streams holds streams that are open or waiting on their handler.
+	streams    map[uint32]*stream
+	sendWindow int64
+	// peerWindow and peerFrameSize come from the client's SETTINGS.
+	peerWindow    int64
+	peerFrameSize uint32
+	closed        bool
+	// recvWindow is the connection receive window. buffered is the request
+	// body bytes held by open streams and withheld the credit not yet
+	// returned because buffered reached maxBuffered.
+	recvWindow  int64
+	buffered    int64
+	withheld    int64
+	maxBuffered int64
+
+	// Read goroutine only.
+	lastStreamID  uint32
+	goAway        bool
+	sawSettings   bool
+	headerStream  uint32
+	headerBlock   []byte
+	headerEndFlag bool
+
+	handlers sync.WaitGroup
+}
+
+// ServeConn speaks HTTP/2 on conn, which must start with the client
+// connection preface, until the client goes away or the connection fails.
+// It closes conn before returning.
+func ServeConn(conn net.Conn, handler Handler) error {
+	return newServerConn(conn, handler).serve(nil)
+}
+
+// ServeUpgradedConn serves a connection that a client switched to HTTP/2
+// with "Upgrade: h2c" (RFC 7540 section 3.2). The caller has already sent
+// the 101 response. req, whose body must already be read, is answered on
+// stream 1 and settings is the decoded HTTP2-Settings header field.
+func ServeUpgradedConn(conn net.Conn, handler Handler, req *request.Request, settings []byte) error {
+	sc := newServerConn(conn, handler)
+	parsed, err := parseSettings(settings)
+	if err == nil {
+		// The 101 response acknowledges these settings.
+		err = sc.applySettings(parsed)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	// The request was sent over HTTP/1.1 and its connection-specific fields
+	// do not carry over.
+	for name := range connectionHeaders {
+		delete(req.Headers, name)
+	}
+	delete(req.Headers, "http2-settings")
+	sc.lastStreamID = 1
+	return sc.serve(&stream{id: 1, req: req, sendWindow: sc.peerWindow})
+}
+
+func newServerConn(conn net.Conn, handler Handler) *serverConn {
+	sc := &serverConn{
+		conn:          conn,
+		reader:        bufio.NewReader(conn),
+		handler:       handler,
+		decoder:       NewDecoder(defaultTableSize),
+		streams:       make(map[uint32]*stream),
+		sendWindow:    defaultWindowSize,
+		peerWindow:    defaultWindowSize,
+		peerFrameSize: defaultFrameSize,
+		recvWindow:    defaultWindowSize,
+		maxBuffered:   maxBufferedBodies,
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	return sc
+}
+
+// serve runs the connection. upgraded, if not nil, is a stream whose
+// request arrived before the connection switched to HTTP/2.
+func (sc *serverConn) serve(upgraded *stream) error {
+	defer sc.shutdown()
+
+	// The server's preface is its SETTINGS frame, which must precede any
+	// response on an upgraded stream.
+	err := sc.writeFrame(settingsFrame(
+		Setting{SettingMaxConcurrentStreams, maxConcurrentStreams},
+		Setting{SettingInitialWindowSize, initialWindowSize},
+		Setting{SettingMaxHeaderListSize, maxHeaderListSize},
+		Setting{SettingEnablePush, 0},
+	))
+	if err != nil {
+		return err
+	}
+	// Grow the connection window to match the stream windows.
+	if err := sc.writeFrame(windowUpdateFrame(0, initialWindowSize-defaultWindowSize)); err != nil {
+		return err
+	}
+	sc.mu.Lock()
+	sc.recvWindow = initialWindowSize
+	sc.mu.Unlock()
+
+	if upgraded != nil {
+		sc.mu.Lock()
+		sc.streams[upgraded.id] = upgraded
+		sc.mu.Unlock()
+		sc.endRequest(upgraded)
+	}
+
+	preface := make([]byte, len(Preface))
+	if _, err := io.ReadFull(sc.reader, preface); err != nil {
+		return sc.fail(err)
+	}
+	if string(preface) != Preface {
+		return errors.New("http2: invalid connection preface")
+	}
+
+	for {
+		f, err := ReadFrame(sc.reader, defaultFrameSize)
+		if err == nil {
+			err = sc.processFrame(f)
+		}
+		var streamErr StreamError
+		if errors.As(err, &streamErr) {
+			sc.resetStream(streamErr.StreamID, streamErr.Code)
+			continue
+		}
+		if err != nil {
+			return sc.fail(err)
+		}
+	}
+}
+
+// fail ends the connection, telling the client why if it broke the protocol.
+func (sc *serverConn) fail(err error) error {
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	var connErr ConnError
+	if errors.As(err, &connErr) {
+		sc.writeFrame(goAwayFrame(sc.lastStreamID, connErr.Code, connErr.Reason))
+	}
+	return err
+}
+
+// shutdown closes the connection and waits for running handlers, whose
+// writes fail from now on.
+func (sc *serverConn) shutdown() {
+	sc.mu.Lock()
+	sc.closed = true
+	for _, st := range sc.streams {
+		st.markGone()
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	sc.conn.Close()
+	sc.handlers.Wait()
+}
+
+func (sc *serverConn) writeFrame(f *Frame) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	return WriteFrame(sc.conn, f)
+}
+
+func (sc *serverConn) processFrame(f *Frame) error {
+	if !sc.sawSettings {
+		if f.Type != FrameSettings || f.Has(FlagAck) {
+			return ConnError{ErrCodeProtocol, "expected SETTINGS after preface"}
+		}
+		sc.sawSettings = true
+	}
+	if sc.headerStream != 0 && (f.Type != FrameContinuation || f.StreamID != sc.headerStream) {
+		return ConnError{ErrCodeProtocol, "expected CONTINUATION"}
+	}
+
+	switch f.Type {
+	case FrameData:
+		return sc.processData(f)
+	case FrameHeaders:
+		return sc.processHeaders(f)
+	case FrameContinuation:
+		return sc.processContinuation(f)
+	case FramePriority:
+		if f.StreamID == 0 {
+			return ConnError{ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if len(f.Payload) != 5 {
+			return StreamError{f.StreamID, ErrCodeFrameSize, "PRIORITY length"}
+		}
+		return nil
+	case FrameRSTStream:
+		return sc.processRSTStream(f)
+	case FrameSettings:
+		return sc.processSettings(f)
+	case FramePushPromise:
+		return ConnError{ErrCodeProtocol, "clients cannot push"}
+	case FramePing:
+		if f.StreamID != 0 {
+			return ConnError{ErrCodeProtocol, "PING on a stream"}
+		}
+		if len(f.Payload) != 8 {
+			return ConnError{ErrCodeFrameSize, "PING length"}
+		}
+		if f.Has(FlagAck) {
+			return nil
+		}
+		return sc.writeFrame(&Frame{Type: FramePing, Flags: FlagAck, Payload: f.Payload})
+	case FrameGoAway:
+		if f.StreamID != 0 {
+			return ConnError{ErrCodeProtocol, "GOAWAY on a stream"}
+		}
+		// The client opens no more streams; those in flight still finish.
+		sc.goAway = true
+		return nil
+	case FrameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	}
+	// Unknown frame types are ignored.
+	return nil
+}
+
+func (sc *serverConn) processSettings(f *Frame) error {
+	if f.StreamID != 0 {
+		return ConnError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+	if f.Has(FlagAck) {
+		if len(f.Payload) != 0 {
+			return ConnError{ErrCodeFrameSize, "SETTINGS ACK with payload"}
+		}
+		return nil
+	}
+	settings, err := parseSettings(f.Payload)
+	if err != nil {
+		return err
+	}
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	return sc.writeFrame(&Frame{Type: FrameSettings, Flags: FlagAck})
+}
+
+// applySettings records the client's SETTINGS parameters.
+func (sc *serverConn) applySettings(settings []Setting) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, s := range settings {
+		switch s.ID {
+		case SettingEnablePush:
+			if s.Value > 1 {
+				return ConnError{ErrCodeProtocol, "invalid ENABLE_PUSH"}
+			}
+		case SettingInitialWindowSize:
+			if s.Value > maxWindowSize {
+				return ConnError{ErrCodeFlowControl, "INITIAL_WINDOW_SIZE too large"}
+			}
+			// The change applies to the windows of every open stream.
+			delta := int64(s.Value) - sc.peerWindow
+			sc.peerWindow = int64(s.Value)
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					return ConnError{ErrCodeFlowControl, "stream window overflow"}
+				}
+			}
+		case SettingMaxFrameSize:
+			if s.Value < defaultFrameSize || s.Value > maxFrameSizeLimit {
+				return ConnError{ErrCodeProtocol, "invalid MAX_FRAME_SIZE"}
+			}
+			sc.peerFrameSize = s.Value
+		}
+		// The encoder never uses the dynamic table, so HEADER_TABLE_SIZE
+		// needs no action; unknown settings are ignored.
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(f *Frame) error {
+	if len(f.Payload) != 4 {
+		return ConnError{ErrCodeFrameSize, "WINDOW_UPDATE length"}
+	}
+	increment := int64(binary.BigEndian.Uint32(f.Payload) & streamIDMask)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if f.StreamID == 0 {
+		if increment == 0 {
+			return ConnError{ErrCodeProtocol, "zero WINDOW_UPDATE"}
+		}
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return ConnError{ErrCodeFlowControl, "connection window overflow"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	if f.StreamID > sc.lastStreamID {
+		return ConnError{ErrCodeProtocol, "WINDOW_UPDATE on idle stream"}
+	}
+	st := sc.streams[f.StreamID]
+	if st == nil {
+		return nil // The stream has already finished.
+	}
+	if increment == 0 {
+		return StreamError{f.StreamID, ErrCodeProtocol, "zero WINDOW_UPDATE"}
+	}
+	st.sendWindow += increment
+	if st.sendWindow > maxWindowSize {
+		return StreamError{f.StreamID, ErrCodeFlowControl, "stream window overflow"}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "RST_STREAM on stream 0"}
+	}
+	if len(f.Payload) != 4 {
+		return ConnError{ErrCodeFrameSize, "RST_STREAM length"}
+	}
+	if f.StreamID > sc.lastStreamID {
+		return ConnError{ErrCodeProtocol, "RST_STREAM on idle stream"}
+	}
+	sc.closeStream(f.StreamID)
+	return nil
+}
+
+func (sc *serverConn) processHeaders(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "HEADERS on stream 0"}
+	}
+	block, err := removePadding(f)
+	if err != nil {
+		return err
+	}
+	if f.Has(FlagPriority) {
+		if len(block) < 5 {
+			return ConnError{ErrCodeFrameSize, "HEADERS priority truncated"}
+		}
+		block = block[5:]
+	}
+
+	sc.headerStream = f.StreamID
+	sc.headerBlock = append(sc.headerBlock[:0], block...)
+	sc.headerEndFlag = f.Has(FlagEndStream)
+	if !f.Has(FlagEndHeaders) {
+		return nil
+	}
+	return sc.endHeaders()
+}
+
+func (sc *serverConn) processContinuation(f *Frame) error {
+	if sc.headerStream == 0 {
+		return ConnError{ErrCodeProtocol, "unexpected CONTINUATION"}
+	}
+	if len(sc.headerBlock)+len(f.Payload) > maxHeaderListSize {
+		return ConnError{ErrCodeEnhanceYourCalm, "header block too large"}
+	}
+	sc.headerBlock = append(sc.headerBlock, f.Payload...)
+	if !f.Has(FlagEndHeaders) {
+		return nil
+	}
+	return sc.endHeaders()
+}
+
+// endHeaders handles a complete header block: either the start of a request
+// or its trailers.
+func (sc *serverConn) endHeaders() error {
+	id, endStream := sc.headerStream, sc.headerEndFlag
+	sc.headerStream = 0
+
+	// The block must be decoded even if the stream is refused, to keep the
+	// dynamic table in step with the client's.
+	fields, err := sc.decoder.Decode(sc.headerBlock)
+	if err != nil {
+		return ConnError{ErrCodeCompression, err.Error()}
+	}
+
+	sc.mu.Lock()
+	st := sc.streams[id]
+	active := len(sc.streams)
+	open := st != nil && st.state == stateOpen
+	sc.mu.Unlock()
+
+	if st != nil {
+		if !open {
+			return StreamError{id, ErrCodeStreamClosed, "HEADERS on half-closed stream"}
+		}
+		if !endStream {
+			return StreamError{id, ErrCodeProtocol, "trailers without END_STREAM"}
+		}
+		for _, f := range fields {
+			if strings.HasPrefix(f.Name, ":") {
+				return StreamError{id, ErrCodeProtocol, "pseudo-header in trailers"}
+			}
+		}
+		sc.endRequest(st)
+		return nil
+	}
+
+	if id%2 == 0 || id <= sc.lastStreamID {
+		return ConnError{ErrCodeProtocol, fmt.Sprintf("invalid stream id %d", id)}
+	}
+	sc.lastStreamID = id
+	if sc.goAway {
+		return nil
+	}
+	if active >= maxConcurrentStreams {
+		return StreamError{id, ErrCodeRefusedStream, "too many concurrent streams"}
+	}
+
+	req, err := newRequest(fields)
+	if err != nil {
+		return StreamError{id, ErrCodeProtocol, err.Error()}
+	}
+	st = &stream{id: id, req: req, recvWindow: initialWindowSize}
+	sc.mu.Lock()
+	st.sendWindow = sc.peerWindow
+	sc.streams[id] = st
+	sc.mu.Unlock()
+
+	if endStream {
+		sc.endRequest(st)
+	}
+	return nil
+}
+
+func (sc *serverConn) processData(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+	// Flow control counts the whole payload, padding included.
+	length := int64(len(f.Payload))
+	sc.mu.Lock()
+	sc.recvWindow -= length
+	exceeded := sc.recvWindow < 0
+	credit := length
+	if sc.buffered >= sc.maxBuffered {
+		sc.withheld += length
+		credit = 0
+	}
+	sc.recvWindow += credit
+	sc.mu.Unlock()
+	if exceeded {
+		return ConnError{ErrCodeFlowControl, "connection window exceeded"}
+	}
+	if credit > 0 {
+		if err := sc.writeFrame(windowUpdateFrame(0, uint32(credit))); err != nil {
+			return err
+		}
+	}
+
+	if f.StreamID > sc.lastStreamID {
+		return ConnError{ErrCodeProtocol, "DATA on idle stream"}
+	}
+	sc.mu.Lock()
+	st := sc.streams[f.StreamID]
+	open := st != nil && st.state == stateOpen
+	sc.mu.Unlock()
+	if !open {
+		return StreamError{f.StreamID, ErrCodeStreamClosed, "DATA on closed stream"}
+	}
+
+	st.recvWindow -= length
+	if st.recvWindow < 0 {
+		return StreamError{f.StreamID, ErrCodeFlowControl, "stream window exceeded"}
+	}
+	data, err := removePadding(f)
+	if err != nil {
+		return err
+	}
+	if len(st.req.Body)+len(data) > MaxBodySize {
+		st.tooLarge = true
+	} else {
+		st.req.Body = append(st.req.Body, data...)
+		sc.mu.Lock()
+		st.buffered += int64(len(data))
+		sc.buffered += int64(len(data))
+		sc.mu.Unlock()
+	}
+
+	if f.Has(FlagEndStream) {
+		sc.endRequest(st)
+		return nil
+	}
+	if length > 0 {
+		st.recvWindow += length
+		return sc.writeFrame(windowUpdateFrame(f.StreamID, uint32(length)))
+	}
+	return nil
+}
+
+// endRequest runs the handler for a stream whose request is complete.
+func (sc *serverConn) endRequest(st *stream) {
+	sc.mu.Lock()
+	st.state = stateHalfClosedRemote
+	sc.mu.Unlock()
+	if value, ok := st.req.Headers["content-length"]; ok && value != fmt.Sprint(len(st.req.Body)) && !st.tooLarge {
+		sc.resetStream(st.id, ErrCodeProtocol)
+		return
+	}
+
+	handler := sc.handler
+	if st.tooLarge {
+		handler = writeTooLarge
+	}
+	sw := &streamWriter{sc: sc, st: st}
+	w := &response.Writer{Writer: sw, Framer: sw, RequestHeaders: st.req.Headers}
+
+	sc.handlers.Add(1)
+	go func() {
+		defer sc.handlers.Done()
+		handler(w, st.req)
+		if err := w.Close(); err != nil {
+			log.Printf("Error finishing HTTP/2 response: %v", err)
+		}
+		if !sw.ended {
+			// The handler sent no response, which HTTP/1.1 reports by
+			// closing the connection.
+			sc.resetStream(st.id, ErrCodeInternal)
+		}
+	}()
+}
+
+func writeTooLarge(w *response.Writer, _ *request.Request) {
+	message := "request body too large"
+	w.WriteStatusLine(413)
+	w.WriteHeaders(response.GetDefaultHeaders(len(message)))
+	w.WriteBody([]byte(message))
+}
+
+// resetStream sends RST_STREAM and forgets the stream.
+func (sc *serverConn) resetStream(id uint32, code ErrCode) {
+	sc.closeStream(id)
+	sc.writeFrame(rstStreamFrame(id, code))
+}
+
+func (sc *serverConn) closeStream(id uint32) {
+	var credit int64
+	sc.mu.Lock()
+	if st := sc.streams[id]; st != nil {
+		st.state = stateClosed
+		st.markGone()
+		delete(sc.streams, id)
+		sc.cond.Broadcast()
+		credit = sc.release(st)
+	}
+	sc.mu.Unlock()
+	if credit > 0 {
+		sc.writeFrame(windowUpdateFrame(0, uint32(credit)))
+	}
+}
+
+// release drops the body of a closed stream from the buffered total and,
+// once the total is back under the cap, returns the connection credit
+// withheld in the meantime for the caller to send. sc.mu must be held.
+func (sc *serverConn) release(st *stream) int64 {
+	sc.buffered -= st.buffered
+	st.buffered = 0
+	if sc.buffered >= sc.maxBuffered {
+		return 0
+	}
+	credit := sc.withheld
+	sc.withheld = 0
+	sc.recvWindow += credit
+	return credit
+}
+
+// connectionHeaders are HTTP/1.1 fields that have no meaning in HTTP/2 and
+// make a message malformed (RFC 9113 section 8.2.2).
+var connectionHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// newRequest builds a request from the fields of a request header block,
+// rejecting malformed ones as RFC 9113 section 8.3 requires.
+func newRequest(fields []HeaderField) (*request.Request, error) {
+	pseudo := map[string]string{}
+	h := headers.NewHeaders()
+	var size uint32
+	for _, f := range fields {
+		size += f.size()
+		if size > maxHeaderListSize {
+			return nil, errors.New("header list too large")
+		}
+		if strings.HasPrefix(f.Name, ":") {
+			if len(h) > 0 {
+				return nil, errors.New("pseudo-header after regular field")
+			}
+			switch f.Name {
+			case ":method", ":scheme", ":authority", ":path":
+			default:
+				return nil, fmt.Errorf("unknown pseudo-header %s", f.Name)
+			}
+			if _, dup := pseudo[f.Name]; dup {
+				return nil, fmt.Errorf("duplicate %s", f.Name)
+			}
+			pseudo[f.Name] = f.Value
+			continue
+		}
+		if f.Name != strings.ToLower(f.Name) || !headers.IsToken(f.Name) {
+			return nil, fmt.Errorf("invalid field name %q", f.Name)
+		}
+		if strings.ContainsAny(f.Value, "\x00\r\n") {
+			return nil, fmt.Errorf("invalid character in field %s", f.Name)
+		}
+		if connectionHeaders[f.Name] || (f.Name == "te" && f.Value != "trailers") {
+			return nil, fmt.Errorf("connection-specific field %s", f.Name)
+		}
+		if existing, ok := h[f.Name]; ok {
+			// Cookies may be split into crumbs (RFC 9113 section 8.2.3).
+			separator := ", "
+			if f.Name == "cookie" {
+				separator = "; "
+			}
+			f.Value = existing + separator + f.Value
+		}
+		h[f.Name] = f.Value
+	}
+
+	method := pseudo[":method"]
+	target := pseudo[":path"]
+	if method == "" {
+		return nil, errors.New("missing :method")
+	}
+	if method == "CONNECT" {
+		if pseudo[":authority"] == "" || pseudo[":scheme"] != "" || target != "" {
+			return nil, errors.New("malformed CONNECT request")
+		}
+		target = pseudo[":authority"]
+	} else if pseudo[":scheme"] == "" || target == "" {
+		return nil, errors.New("missing :scheme or :path")
+	}
+	if authority, ok := pseudo[":authority"]; ok {
+		if _, hasHost := h["host"]; !hasHost {
+			h["host"] = authority
+		}
+	}
+	parsed, err := request.ParseTarget(method, target)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Form == request.AbsoluteForm {
+		return nil, errors.New(":path is not an absolute path")
+	}
+
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        method,
+			RequestTarget: target,
+			HTTPVersion:   "2",
+		},
+		Target:  parsed,
+		Headers: h,
+	}, nil
+}
+
+// streamWriter is the response.Framer for one stream. It is only used by
+// the stream's handler goroutine.
+type streamWriter struct {
+	sc    *serverConn
+	st    *stream
+	ended bool
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	return sw.WriteData(p)
+}
+
+// WriteHead sends the status and headers in a HEADERS frame.
+func (sw *streamWriter) WriteHead(statusCode response.StatusCode, h headers.Headers) error {
+	fields := []HeaderField{{Name: ":status", Value: fmt.Sprint(int(statusCode))}}
+	return sw.writeHeaderBlock(append(fields, headerFields(h)...), false)
+}
+
+// WriteData sends p in DATA frames as flow control allows.
+func (sw *streamWriter) WriteData(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n, err := sw.sc.reserve(sw.st, len(p))
+		if err != nil {
+			return written, err
+		}
+		err = sw.sc.writeFrame(&Frame{Type: FrameData, StreamID: sw.st.id, Payload: p[:n]})
+		if err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// CloseNotify returns a channel that is closed once the stream is reset by
+// the client or ended, or the connection closes.
+func (sw *streamWriter) CloseNotify() <-chan struct{} {
+	sc := sw.sc
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sw.st.gone == nil {
+		sw.st.gone = make(chan struct{})
+		if sc.closed || sw.st.state == stateClosed {
+			sw.st.markGone()
+		}
+	}
+	return sw.st.gone
+}
+
+// WriteEnd ends the stream, with a trailing HEADERS frame if there are trailers.
+func (sw *streamWriter) WriteEnd(trailer headers.Headers) error {
+	sw.ended = true
+	defer sw.sc.closeStream(sw.st.id)
+	if len(trailer) > 0 {
+		return sw.writeHeaderBlock(headerFields(trailer), true)
+	}
+	return sw.sc.writeFrame(&Frame{Type: FrameData, Flags: FlagEndStream, StreamID: sw.st.id})
+}
+
+func (sw *streamWriter) writeHeaderBlock(fields []HeaderField, endStream bool) error {
+	sc := sw.sc
+	sc.mu.Lock()
+	frameSize, closed := int(sc.peerFrameSize), sc.closed || sw.st.state == stateClosed
+	sc.mu.Unlock()
+	if closed {
+		return errConnClosed
+	}
+
+	block := sc.encoder.Encode(nil, fields)
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	frameType := FrameHeaders
+	for first := true; first || len(block) > 0; first = false {
+		chunk := block[:min(len(block), frameSize)]
+		block = block[len(chunk):]
+		f := &Frame{Type: frameType, StreamID: sw.st.id, Payload: chunk}
+		if first && endStream {
+			f.Flags |= FlagEndStream
+		}
+		if len(block) == 0 {
+			f.Flags |= FlagEndHeaders
+		}
+		if err := WriteFrame(sc.conn, f); err != nil {
+			return err
+		}
+		frameType = FrameContinuation
+	}
+	return nil
+}
+
+// reserve waits until up to want bytes may be sent on st and takes them out
+// of the stream and connection send windows.
+func (sc *serverConn) reserve(st *stream, want int) (int, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for {
+		if sc.closed {
+			return 0, errConnClosed
+		}
+		if st.state == stateClosed {
+			return 0, errors.New("http2: stream reset by client")
+		}
+		n := min(int64(want), st.sendWindow, sc.sendWindow, int64(sc.peerFrameSize))
+		if n > 0 {
+			st.sendWindow -= n
+			sc.sendWindow -= n
+			return int(n), nil
+		}
+		sc.cond.Wait()
+	}
+}
+
+// headerFields converts response headers to lowercase HTTP/2 fields,
+// dropping those specific to HTTP/1.1 connections.
+func headerFields(h headers.Headers) []HeaderField {
+	fields := make([]HeaderField, 0, len(h))
+	for name, value := range h {
+		name = strings.ToLower(name)
+		if connectionHeaders[name] {
+			continue
+		}
+		for _, line := range strings.Split(value, headers.LineSeparator) {
+			fields = append(fields, HeaderField{Name: name, Value: line})
+		}
+	}
+	return fields
+}
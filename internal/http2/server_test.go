@@ -0,0 +1,258 @@
+package http2
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClient drives a server connection with hand-built frames.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	dec    *Decoder
+	writes chan *Frame
+}
+
+// newTestClient serves a connection with handler; configure, if given, can
+// adjust the server connection before it starts.
+func newTestClient(t *testing.T, handler Handler, configure ...func(*serverConn)) *testClient {
+	t.Helper()
+	client, serverSide := net.Pipe()
+	sc := newServerConn(serverSide, handler)
+	for _, f := range configure {
+		f(sc)
+	}
+	go sc.serve(nil)
+	t.Cleanup(func() { client.Close() })
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	c := &testClient{t: t, conn: client, dec: NewDecoder(defaultTableSize), writes: make(chan *Frame, 16)}
+	// Frames are written in order from one goroutine, so that reading the
+	// server's frames never waits on our writes.
+	go func() {
+		io.WriteString(client, Preface)
+		for f := range c.writes {
+			WriteFrame(client, f)
+		}
+	}()
+	c.write(settingsFrame())
+	// The server's SETTINGS and connection WINDOW_UPDATE come first.
+	f := c.read()
+	require.Equal(t, FrameSettings, f.Type)
+	require.Equal(t, FrameWindowUpdate, c.read().Type)
+	return c
+}
+
+func (c *testClient) write(f *Frame) {
+	c.writes <- f
+}
+
+// read returns the next frame, skipping SETTINGS acknowledgements.
+func (c *testClient) read() *Frame {
+	c.t.Helper()
+	for {
+		f, err := ReadFrame(c.conn, maxFrameSizeLimit)
+		require.NoError(c.t, err)
+		if f.Type == FrameSettings && f.Has(FlagAck) {
+			continue
+		}
+		return f
+	}
+}
+
+func (c *testClient) headers(streamID uint32, endStream bool, fields ...HeaderField) {
+	flags := FlagEndHeaders
+	if endStream {
+		flags |= FlagEndStream
+	}
+	c.write(&Frame{Type: FrameHeaders, Flags: flags, StreamID: streamID, Payload: Encoder{}.Encode(nil, fields)})
+}
+
+func requestFields(method, path string) []HeaderField {
+	return []HeaderField{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":path", Value: path},
+		{Name: ":authority", Value: "localhost"},
+	}
+}
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.StatusCodeOk)
+	w.WriteHeaders(headers.Headers{
+		"Content-Type":      "text/plain",
+		"Transfer-Encoding": "chunked",
+		"Trailer":           "X-Path",
+		"X-Host":            req.Headers["host"],
+	})
+	w.WriteBody(append([]byte(req.RequestLine.Method+" "), req.Body...))
+	w.WriteTrailer(headers.Headers{"X-Path": req.RequestLine.RequestTarget})
+}
+
+func TestServeRequest(t *testing.T) {
+	c := newTestClient(t, echoHandler)
+
+	// Test: Request body split over DATA frames, response with trailers
+	c.headers(1, false, requestFields("POST", "/echo")...)
+	c.write(&Frame{Type: FrameData, StreamID: 1, Payload: []byte("hello ")})
+	c.write(&Frame{Type: FrameData, Flags: FlagEndStream, StreamID: 1, Payload: []byte("world")})
+
+	var fields []HeaderField
+	var body strings.Builder
+	for {
+		f := c.read()
+		if f.Type == FrameWindowUpdate {
+			continue
+		}
+		require.Equal(t, uint32(1), f.StreamID)
+		switch f.Type {
+		case FrameHeaders:
+			decoded, err := c.dec.Decode(f.Payload)
+			require.NoError(t, err)
+			fields = append(fields, decoded...)
+		case FrameData:
+			body.Write(f.Payload)
+		default:
+			t.Fatalf("unexpected frame type %d", f.Type)
+		}
+		if f.Has(FlagEndStream) {
+			break
+		}
+	}
+	assert.Equal(t, "POST hello world", body.String())
+	assert.Equal(t, HeaderField{Name: ":status", Value: "200"}, fields[0])
+	assert.Contains(t, fields, HeaderField{Name: "x-host", Value: "localhost"})
+	assert.Contains(t, fields, HeaderField{Name: "x-path", Value: "/echo"})
+	for _, f := range fields {
+		assert.NotEqual(t, "transfer-encoding", f.Name)
+	}
+
+	// Test: PING is acknowledged with the same payload
+	c.write(&Frame{Type: FramePing, Payload: []byte("12345678")})
+	f := c.read()
+	assert.Equal(t, FramePing, f.Type)
+	assert.True(t, f.Has(FlagAck))
+	assert.Equal(t, "12345678", string(f.Payload))
+}
+
+func TestServeFlowControl(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	c := newTestClient(t, func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	})
+
+	// Test: The server only sends as much DATA as the stream window allows
+	c.write(settingsFrame(Setting{SettingInitialWindowSize, 30}))
+	c.headers(1, true, requestFields("GET", "/")...)
+	require.Equal(t, FrameHeaders, c.read().Type)
+	f := c.read()
+	require.Equal(t, FrameData, f.Type)
+	assert.Len(t, f.Payload, 30)
+
+	c.write(windowUpdateFrame(1, 70))
+	received := 30
+	for received < len(body) {
+		f = c.read()
+		require.Equal(t, FrameData, f.Type)
+		received += len(f.Payload)
+	}
+	assert.Equal(t, len(body), received)
+	f = c.read()
+	assert.True(t, f.Has(FlagEndStream))
+}
+
+func TestServeBufferedBodies(t *testing.T) {
+	c := newTestClient(t, echoHandler, func(sc *serverConn) { sc.maxBuffered = 100 })
+
+	// Test: Connection credit is returned while buffered bodies are under the cap
+	c.headers(1, false, requestFields("POST", "/echo")...)
+	c.write(&Frame{Type: FrameData, StreamID: 1, Payload: []byte(strings.Repeat("x", 150))})
+	f := c.read()
+	require.Equal(t, FrameWindowUpdate, f.Type)
+	assert.Equal(t, uint32(0), f.StreamID)
+	assert.Equal(t, uint32(150), binary.BigEndian.Uint32(f.Payload))
+	f = c.read()
+	require.Equal(t, FrameWindowUpdate, f.Type)
+	assert.Equal(t, uint32(1), f.StreamID)
+
+	// Test: Past the cap it is withheld until the stream closes
+	c.write(&Frame{Type: FrameData, Flags: FlagEndStream, StreamID: 1, Payload: []byte("yy")})
+	for f = c.read(); !f.Has(FlagEndStream); f = c.read() {
+		assert.NotEqual(t, FrameWindowUpdate, f.Type)
+	}
+	f = c.read()
+	require.Equal(t, FrameWindowUpdate, f.Type)
+	assert.Equal(t, uint32(0), f.StreamID)
+	assert.Equal(t, uint32(2), binary.BigEndian.Uint32(f.Payload))
+}
+
+func TestServeCloseNotify(t *testing.T) {
+	notified := make(chan struct{})
+	c := newTestClient(t, func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(headers.Headers{"Content-Type": "text/event-stream", "Transfer-Encoding": "chunked"})
+		notifier, ok := w.Writer.(response.CloseNotifier)
+		require.True(t, ok)
+		select {
+		case <-notifier.CloseNotify():
+			close(notified)
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	// Test: Resetting the stream notifies its handler without any write
+	c.headers(1, true, requestFields("GET", "/events")...)
+	require.Equal(t, FrameHeaders, c.read().Type)
+	c.write(rstStreamFrame(1, ErrCodeCancel))
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Fatal("handler was not told the stream was reset")
+	}
+}
+
+func TestServeProtocolErrors(t *testing.T) {
+	// Test: Malformed request resets only the stream
+	c := newTestClient(t, echoHandler)
+	c.headers(1, true, append(requestFields("GET", "/"), HeaderField{Name: "Upper", Value: "x"})...)
+	f := c.read()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(1), f.StreamID)
+	assert.Equal(t, ErrCodeProtocol, ErrCode(binary.BigEndian.Uint32(f.Payload)))
+
+	c.headers(3, true, requestFields("GET", "/ok")...)
+	f = c.read()
+	assert.Equal(t, FrameHeaders, f.Type)
+	assert.Equal(t, uint32(3), f.StreamID)
+
+	// Test: Reusing a lower stream id is a connection error
+	c = newTestClient(t, echoHandler)
+	c.headers(5, true, requestFields("GET", "/")...)
+	for f = c.read(); !f.Has(FlagEndStream); f = c.read() {
+	}
+	c.headers(3, true, requestFields("GET", "/")...)
+	f = c.read()
+	require.Equal(t, FrameGoAway, f.Type)
+	assert.Equal(t, uint32(5), binary.BigEndian.Uint32(f.Payload))
+	assert.Equal(t, ErrCodeProtocol, ErrCode(binary.BigEndian.Uint32(f.Payload[4:])))
+
+	// Test: Invalid header block is a compression error
+	c = newTestClient(t, echoHandler)
+	c.write(&Frame{Type: FrameHeaders, Flags: FlagEndHeaders | FlagEndStream, StreamID: 1, Payload: []byte{0xff}})
+	f = c.read()
+	require.Equal(t, FrameGoAway, f.Type)
+	assert.Equal(t, ErrCodeCompression, ErrCode(binary.BigEndian.Uint32(f.Payload[4:])))
+}
@@ -0,0 +1,122 @@
+package request
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnsupportedEncoding is returned when a body uses a content coding
+	// that cannot be decoded.
+	ErrUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+	// ErrBodyTooLarge is returned when a decoded body grows past its limit.
+	ErrBodyTooLarge = errors.New("decoded body exceeds size limit")
+)
+
+// SupportedEncodings lists the content codings DecodeContentEncoding can remove.
+var SupportedEncodings = []string{"gzip", "deflate"}
+
+// DecodeContentEncoding removes any gzip or deflate Content-Encoding from the
+// body, refusing to produce more than limit decoded bytes. A body already in
+// memory is decoded in place; a streamed body is decoded as BodyReader is
+// read. The Content-Encoding header is dropped once the body is decoded, and
+// the body as received stays available from EncodedBody.
+func (r *Request) DecodeContentEncoding(limit int64) error {
+	value := r.Headers["content-encoding"]
+	if value == "" {
+		return nil
+	}
+
+	var codings []string
+	for _, coding := range strings.Split(value, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		switch coding {
+		case "identity", "":
+			continue
+		case "gzip", "x-gzip", "deflate":
+			codings = append(codings, coding)
+		default:
+			return fmt.Errorf("%w: %s", ErrUnsupportedEncoding, coding)
+		}
+	}
+	delete(r.Headers, "content-encoding")
+	if len(codings) == 0 || !r.HasBody() {
+		return nil
+	}
+
+	if r.streaming {
+		// Keep the coding's header, which the decoder reads straight away.
+		r.coded = new(bytes.Buffer)
+		decoder, err := decodeReader(&bodyReader{req: r}, codings, limit)
+		r.encoded, r.coded = r.coded.Bytes(), nil
+		if err != nil {
+			return err
+		}
+		r.decoder = decoder
+		// The framing headers describe the encoded body, not what BodyReader yields.
+		delete(r.Headers, "content-length")
+		return nil
+	}
+
+	decoder, err := decodeReader(bytes.NewReader(r.Body), codings, limit)
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(decoder)
+	if err != nil {
+		return err
+	}
+	r.encoded = r.Body
+	r.Body = body
+	r.Headers["content-length"] = strconv.Itoa(len(body))
+	return nil
+}
+
+// decodeReader undoes codings, which are listed in the order they were applied.
+func decodeReader(body io.Reader, codings []string, limit int64) (io.Reader, error) {
+	reader := body
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch codings[i] {
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(reader)
+		case "deflate":
+			reader, err = zlib.NewReader(reader)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s body: %w", codings[i], err)
+		}
+	}
+	return &limitedReader{reader: reader, remaining: limit, err: ErrBodyTooLarge}, nil
+}
+
+// limitedReader fails with err instead of silently truncating.
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+	err       error
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Only fail if there really is more data.
+		var probe [1]byte
+		n, err := l.reader.Read(probe[:])
+		if n > 0 {
+			return 0, l.err
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
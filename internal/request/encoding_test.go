@@ -0,0 +1,105 @@
+package request
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipString(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.String()
+}
+
+func deflateString(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.String()
+}
+
+func encodedRequest(encoding, body string) string {
+	return "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Encoding: " + encoding + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" +
+		body
+}
+
+func TestDecodeContentEncoding(t *testing.T) {
+	// Test: gzip body decoded in place
+	gzipped := gzipString(t, "hello world!")
+	reader := &chunkReader{data: encodedRequest("gzip", gzipped), numBytesPerRead: 7}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeContentEncoding(1024))
+	assert.Equal(t, "hello world!", string(r.Body))
+	assert.Equal(t, "12", r.Headers["content-length"])
+	assert.Empty(t, r.Headers["content-encoding"])
+	assert.Equal(t, gzipped, string(r.EncodedBody()))
+
+	// Test: Stacked codings are removed in reverse order
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("gzip, deflate", deflateString(t, gzipString(t, "layers")))))
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeContentEncoding(1024))
+	assert.Equal(t, "layers", string(r.Body))
+
+	// Test: Streamed deflate body
+	reader = &chunkReader{data: encodedRequest("deflate", deflateString(t, "streamed body")), numBytesPerRead: 3}
+	r, err = RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeContentEncoding(1024))
+	assert.True(t, r.HasBody())
+	assert.Empty(t, r.Headers["content-length"])
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "streamed body", string(body))
+
+	// Test: A streamed body read in full keeps its coded bytes
+	deflated := deflateString(t, "streamed body")
+	reader = &chunkReader{data: encodedRequest("deflate", deflated), numBytesPerRead: 3}
+	r, err = RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeContentEncoding(1024))
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, "streamed body", string(r.Body))
+	assert.Equal(t, deflated, string(r.EncodedBody()))
+
+	// Test: Unsupported encoding
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("br", "xxxx")))
+	require.NoError(t, err)
+	require.ErrorIs(t, r.DecodeContentEncoding(1024), ErrUnsupportedEncoding)
+
+	// Test: Decoded size limit guards against zip bombs
+	bomb := gzipString(t, strings.Repeat("A", 1<<20))
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("gzip", bomb)))
+	require.NoError(t, err)
+	require.ErrorIs(t, r.DecodeContentEncoding(1024), ErrBodyTooLarge)
+
+	// Test: Body exactly at the limit
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("gzip", gzipString(t, "1234"))))
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeContentEncoding(4))
+	assert.Equal(t, "1234", string(r.Body))
+
+	// Test: Corrupt gzip data
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("gzip", "not gzip")))
+	require.NoError(t, err)
+	require.Error(t, r.DecodeContentEncoding(1024))
+}
@@ -0,0 +1,212 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+)
+
+var (
+	// ErrNotForm is returned by ParseForm for a body that is neither
+	// urlencoded nor multipart/form-data.
+	ErrNotForm = errors.New("request body is not a form")
+	// ErrFormTooLarge is returned when a form or one of its parts grows
+	// past its limit.
+	ErrFormTooLarge = errors.New("form exceeds size limit")
+)
+
+// FormLimits bounds the resources ParseForm may use.
+type FormLimits struct {
+	// MaxSize is the largest body accepted, counting multipart framing.
+	MaxSize int64
+	// MaxPartSize is the largest value or file accepted for a single part.
+	MaxPartSize int64
+	// MaxMemory is how many bytes of file content are held in memory. Files
+	// that do not fit are written to temporary files.
+	MaxMemory int64
+}
+
+// DefaultFormLimits are used for any zero field of the limits given to ParseForm.
+var DefaultFormLimits = FormLimits{
+	MaxSize:     32 << 20,
+	MaxPartSize: 10 << 20,
+	MaxMemory:   1 << 20,
+}
+
+// Form is a parsed form body.
+type Form struct {
+	// Values holds the urlencoded fields or the multipart parts without a
+	// filename.
+	Values url.Values
+	// Files holds the multipart parts with a filename, by field name.
+	Files map[string][]*FormFile
+}
+
+// FormFile is an uploaded file.
+type FormFile struct {
+	Filename string
+	// Header holds the part's headers with lowercase names.
+	Header headers.Headers
+	Size   int64
+	// content holds the file when it fit in memory; otherwise it is in path.
+	content []byte
+	path    string
+}
+
+// Open returns a reader over the file's content.
+func (f *FormFile) Open() (io.ReadCloser, error) {
+	if f.path != "" {
+		return os.Open(f.path)
+	}
+	return io.NopCloser(bytes.NewReader(f.content)), nil
+}
+
+// RemoveAll deletes the temporary files of the form. Handlers that parse a
+// multipart form should defer it.
+func (f *Form) RemoveAll() error {
+	var errs []error
+	for _, files := range f.Files {
+		for _, file := range files {
+			if file.path == "" {
+				continue
+			}
+			if err := os.Remove(file.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseForm reads and parses an application/x-www-form-urlencoded or
+// multipart/form-data body. File parts are streamed from the body, so a
+// request read with RequestHeadFromReader is never held in memory whole.
+// The body is consumed. On error any temporary files are already removed.
+func (r *Request) ParseForm(limits FormLimits) (*Form, error) {
+	limits = limits.withDefaults()
+	mediaType, params, err := mime.ParseMediaType(r.Headers["content-type"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotForm, err)
+	}
+	body := &limitedReader{reader: r.BodyReader(), remaining: limits.MaxSize, err: ErrFormTooLarge}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		values, err := parseQuery(string(raw))
+		if err != nil {
+			return nil, err
+		}
+		return &Form{Values: values, Files: map[string][]*FormFile{}}, nil
+	case "multipart/form-data":
+		if params["boundary"] == "" {
+			return nil, fmt.Errorf("%w: missing multipart boundary", ErrNotForm)
+		}
+		form := &Form{Values: url.Values{}, Files: map[string][]*FormFile{}}
+		if err := form.readMultipart(multipart.NewReader(body, params["boundary"]), limits); err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+		return form, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNotForm, mediaType)
+	}
+}
+
+func (l FormLimits) withDefaults() FormLimits {
+	if l.MaxSize <= 0 {
+		l.MaxSize = DefaultFormLimits.MaxSize
+	}
+	if l.MaxPartSize <= 0 {
+		l.MaxPartSize = DefaultFormLimits.MaxPartSize
+	}
+	if l.MaxMemory <= 0 {
+		l.MaxMemory = DefaultFormLimits.MaxMemory
+	}
+	return l
+}
+
+// readMultipart adds every part of mr to the form.
+func (f *Form) readMultipart(mr *multipart.Reader, limits FormLimits) error {
+	memory := limits.MaxMemory
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid multipart body: %w", err)
+		}
+
+		name := part.FormName()
+		if name == "" {
+			part.Close()
+			continue
+		}
+		content := &limitedReader{
+			reader:    part,
+			remaining: limits.MaxPartSize,
+			err:       fmt.Errorf("%w: part %q", ErrFormTooLarge, name),
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(content)
+			if err != nil {
+				return err
+			}
+			f.Values[name] = append(f.Values[name], string(value))
+			continue
+		}
+
+		file := &FormFile{Filename: part.FileName(), Header: partHeader(part)}
+		f.Files[name] = append(f.Files[name], file)
+		if err := file.store(content, &memory); err != nil {
+			return err
+		}
+	}
+}
+
+// store reads the file's content, keeping it in memory while it fits in the
+// remaining budget and spilling it to a temporary file otherwise.
+func (f *FormFile) store(content io.Reader, memory *int64) error {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(content, *memory+1))
+	if err != nil {
+		return err
+	}
+	if n <= *memory {
+		*memory -= n
+		f.content = buf.Bytes()
+		f.Size = n
+		return nil
+	}
+
+	tmp, err := os.CreateTemp("", "form-upload-")
+	if err != nil {
+		return err
+	}
+	defer tmp.Close()
+	// Set path first so RemoveAll cleans up after a failed copy.
+	f.path = tmp.Name()
+	f.Size, err = io.Copy(tmp, io.MultiReader(&buf, content))
+	return err
+}
+
+func partHeader(part *multipart.Part) headers.Headers {
+	h := headers.NewHeaders()
+	for name, values := range part.Header {
+		h[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+	return h
+}
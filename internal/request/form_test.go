@@ -0,0 +1,124 @@
+package request
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBoundary = "XyZ"
+
+func multipartRequest(t *testing.T, body string) *Request {
+	t.Helper()
+	body = strings.ReplaceAll(body, "\n", "\r\n")
+	raw := "POST /upload HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Type: multipart/form-data; boundary=" + testBoundary + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	r, err := RequestHeadFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return r
+}
+
+const multipartBody = `--XyZ
+Content-Disposition: form-data; name="title"
+
+Holiday
+--XyZ
+Content-Disposition: form-data; name="tag"
+
+sea
+--XyZ
+Content-Disposition: form-data; name="tag"
+
+sun
+--XyZ
+Content-Disposition: form-data; name="photo"; filename="small.txt"
+Content-Type: text/plain
+
+tiny
+--XyZ
+Content-Disposition: form-data; name="photo"; filename="big.txt"
+Content-Type: text/plain
+
+0123456789abcdef
+--XyZ--
+`
+
+func TestParseForm(t *testing.T) {
+	// Test: URL-encoded body
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Type: application/x-www-form-urlencoded\r\nContent-Length: 27\r\n\r\n" +
+		"a=1&a=2&b=hello+world%21&c="))
+	require.NoError(t, err)
+	form, err := r.ParseForm(FormLimits{})
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{"a": {"1", "2"}, "b": {"hello world!"}, "c": {""}}, form.Values)
+	assert.Empty(t, form.Files)
+
+	// Test: Multipart values and files, the second file spilling to disk
+	r = multipartRequest(t, multipartBody)
+	form, err = r.ParseForm(FormLimits{MaxMemory: 8})
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{"title": {"Holiday"}, "tag": {"sea", "sun"}}, form.Values)
+	require.Len(t, form.Files["photo"], 2)
+
+	small := form.Files["photo"][0]
+	assert.Equal(t, "small.txt", small.Filename)
+	assert.Equal(t, "text/plain", small.Header["content-type"])
+	assert.Equal(t, int64(4), small.Size)
+	assert.Empty(t, small.path)
+	assertFileContent(t, small, "tiny")
+
+	big := form.Files["photo"][1]
+	assert.Equal(t, int64(16), big.Size)
+	require.NotEmpty(t, big.path)
+	assertFileContent(t, big, "0123456789abcdef")
+
+	// Test: RemoveAll deletes the temporary file
+	require.NoError(t, form.RemoveAll())
+	_, err = os.Stat(big.path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func assertFileContent(t *testing.T, f *FormFile, want string) {
+	t.Helper()
+	rc, err := f.Open()
+	require.NoError(t, err)
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(got))
+}
+
+func TestParseFormLimits(t *testing.T) {
+	// Test: A part over MaxPartSize
+	r := multipartRequest(t, multipartBody)
+	_, err := r.ParseForm(FormLimits{MaxPartSize: 10, MaxMemory: 1})
+	require.ErrorIs(t, err, ErrFormTooLarge)
+	assert.Contains(t, err.Error(), `"photo"`)
+
+	// Test: A body over MaxSize
+	r = multipartRequest(t, multipartBody)
+	_, err = r.ParseForm(FormLimits{MaxSize: 100})
+	require.ErrorIs(t, err, ErrFormTooLarge)
+
+	// Test: Not a form
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Type: application/json\r\nContent-Length: 2\r\n\r\n{}"))
+	require.NoError(t, err)
+	_, err = r.ParseForm(FormLimits{})
+	require.ErrorIs(t, err, ErrNotForm)
+
+	// Test: Malformed percent-encoding
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Type: application/x-www-form-urlencoded\r\nContent-Length: 3\r\n\r\na=%"))
+	require.NoError(t, err)
+	_, err = r.ParseForm(FormLimits{})
+	require.ErrorIs(t, err, ErrInvalidEscape)
+}
@@ -0,0 +1,54 @@
+package request
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+var (
+	// ErrNotJSON is returned by DecodeJSON when the body is not declared as JSON.
+	ErrNotJSON = errors.New("request body is not JSON")
+	// ErrInvalidJSON is returned by DecodeJSON for a body that is not a
+	// single JSON value matching the destination.
+	ErrInvalidJSON = errors.New("invalid JSON body")
+)
+
+// DecodeJSON decodes a JSON body into v, reading at most limit bytes. The
+// Content-Type must be application/json or end in "+json", the body must hold
+// exactly one value, and objects may only contain fields that v has.
+func (r *Request) DecodeJSON(v any, limit int64) error {
+	mediaType, _, err := mime.ParseMediaType(r.Headers["content-type"])
+	if err != nil || mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return fmt.Errorf("%w: Content-Type %q", ErrNotJSON, r.Headers["content-type"])
+	}
+
+	body := &limitedReader{reader: r.BodyReader(), remaining: limit, err: ErrBodyTooLarge}
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return jsonError(err)
+	}
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		if err == nil {
+			err = errors.New("unexpected data after JSON value")
+		}
+		return jsonError(err)
+	}
+	return nil
+}
+
+// jsonError wraps a decoding error in ErrInvalidJSON unless it came from
+// reading the body.
+func jsonError(err error) error {
+	if errors.Is(err, ErrBodyTooLarge) {
+		return err
+	}
+	if err == io.EOF {
+		err = errors.New("empty body")
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+}
@@ -0,0 +1,60 @@
+package request
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func jsonRequest(t *testing.T, contentType, body string) *Request {
+	t.Helper()
+	raw := "POST /items HTTP/1.1\r\nHost: localhost\r\n"
+	if contentType != "" {
+		raw += "Content-Type: " + contentType + "\r\n"
+	}
+	raw += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	r, err := RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return r
+}
+
+func TestDecodeJSON(t *testing.T) {
+	type item struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	// Test: Valid body, with a media type parameter and a +json suffix
+	var got item
+	require.NoError(t, jsonRequest(t, "application/json; charset=utf-8", `{"name":"box","count":2}`).DecodeJSON(&got, 1024))
+	assert.Equal(t, item{Name: "box", Count: 2}, got)
+	require.NoError(t, jsonRequest(t, "application/merge-patch+json", `{"count":3} `).DecodeJSON(&got, 1024))
+	assert.Equal(t, 3, got.Count)
+
+	// Test: Rejected bodies
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		err         error
+	}{
+		{"unknown field", "application/json", `{"name":"box","colour":"red"}`, ErrInvalidJSON},
+		{"syntax error", "application/json", `{"name":`, ErrInvalidJSON},
+		{"wrong type", "application/json", `{"count":"two"}`, ErrInvalidJSON},
+		{"trailing value", "application/json", `{"count":1}{"count":2}`, ErrInvalidJSON},
+		{"empty body", "application/json", ``, ErrInvalidJSON},
+		{"too large", "application/json", `{"name":"` + strings.Repeat("x", 2000) + `"}`, ErrBodyTooLarge},
+		{"not JSON", "text/plain", `{}`, ErrNotJSON},
+		{"no Content-Type", "", `{}`, ErrNotJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v item
+			err := jsonRequest(t, tt.contentType, tt.body).DecodeJSON(&v, 1024)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
@@ -1,26 +1,74 @@
 package request
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"strconv"
 	"strings"
 
+	"httpfromtcp/internal/cookie"
 	"httpfromtcp/internal/headers"
 )
 
 const (
 	CRLF       = "\r\n"
 	bufferSize = 8
+	// maxDiscard is how much of an unread body DiscardBody drops to reuse
+	// the connection. Past it, closing the connection is cheaper.
+	maxDiscard = 256 << 10
 )
 
+// ErrUnsupportedVersion is returned for a well-formed request line whose
+// major HTTP version is not 1.
+var ErrUnsupportedVersion = errors.New("unsupported HTTP version")
+
+// ErrUnsupportedTransferCoding is returned for a Transfer-Encoding other
+// than chunked.
+var ErrUnsupportedTransferCoding = errors.New("unsupported transfer coding")
+
+// ErrDiscardLimit is returned by DiscardBody when too much of the body is
+// left unread to be worth draining.
+var ErrDiscardLimit = errors.New("unread body exceeds discard limit")
+
 // Request represents an HTTP request with its request line.
 type Request struct {
 	RequestLine RequestLine
-	Headers     map[string]string
-	Body        []byte
-	state       requestState
+	// Target is the parsed RequestLine.RequestTarget.
+	Target  Target
+	Headers map[string]string
+	Body    []byte
+	// TLS describes the connection the request arrived on, or is nil if it
+	// was not TLS. It is set by the server, not the parser.
+	TLS       *TLSInfo
+	state     requestState
+	remaining int
+	stream    *streamReader
+	streaming bool
+	pending   []byte
+	hasBody   bool
+	decoder   io.Reader
+	// encoded is the body as received when DecodeContentEncoding has
+	// replaced Body. For a stream it starts as what the decoder read up
+	// front, and coded collects the rest while ReadBody decodes it.
+	encoded []byte
+	coded   *bytes.Buffer
+	strict  bool
+}
+
+// Option configures how a request is parsed.
+type Option func(*Request)
+
+// Strict makes the parser follow the RFC 9112 grammar exactly: a request
+// line of single-space separated parts, token methods, targets made only of
+// URI characters, and header fields without control characters or obsolete
+// line folding. By default these are accepted for compatibility.
+func Strict() Option {
+	return func(r *Request) {
+		r.strict = true
+	}
 }
 
 type requestState int
@@ -29,6 +77,10 @@ const (
 	requestStateInit requestState = iota
 	requestStateParsingHeaders
 	requestStateParsingBody
+	requestStateParsingChunkSize
+	requestStateParsingChunkData
+	requestStateParsingChunkEnd
+	requestStateParsingTrailers
 	requestStateDone
 )
 
@@ -63,60 +115,108 @@ func (r *Request) parse(data []byte) (int, error) {
 func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.state {
 	case requestStateInit:
-		requestLine, bytesParsed, err := parseRequestLine(string(data))
+		requestLine, bytesParsed, err := parseRequestLine(string(data), r.strict)
 		if err != nil {
 			return 0, err
 		}
 		if bytesParsed == 0 {
 			return 0, nil // Not enough data to parse
 		}
+		target, err := ParseTarget(requestLine.Method, requestLine.RequestTarget)
+		if err != nil {
+			return 0, err
+		}
 		r.RequestLine = requestLine
+		r.Target = target
 		r.state = requestStateParsingHeaders
 		return bytesParsed, nil
 
 	case requestStateParsingHeaders:
 		headersMap := headers.NewHeaders()
-		bytesParsed, done, err := headersMap.Parse(data)
+		parse := headersMap.Parse
+		if r.strict {
+			parse = headersMap.ParseStrict
+		}
+		bytesParsed, done, err := parse(data)
 		if err != nil {
 			return 0, err
 		}
 		if done {
 			r.Headers = headersMap
-			if headersMap.Get("Content-Length") != "" {
-				r.state = requestStateParsingBody
-			} else {
-				r.state = requestStateDone
+			if err := r.startBody(); err != nil {
+				return 0, err
 			}
 			return bytesParsed + len(CRLF), nil
 		}
 		return 0, nil // Not enough data to parse
 
 	case requestStateParsingBody:
-		contentLengthStr, err := strconv.Atoi(r.Headers["content-length"])
-		if err != nil {
-			return 0, errors.New("invalid Content-Length header")
+		toAppend := data
+		if len(data) > r.remaining {
+			toAppend = data[:r.remaining]
+		}
+		r.appendBody(toAppend)
+		r.remaining -= len(toAppend)
+		if r.remaining == 0 {
+			r.state = requestStateDone
 		}
+		return len(toAppend), nil
 
-		remainingLength := contentLengthStr - len(r.Body)
-		if remainingLength < 0 {
-			return 0, errors.New("body length exceeds Content-Length header")
+	case requestStateParsingChunkSize:
+		lineEnd := strings.Index(string(data), CRLF)
+		if lineEnd == -1 {
+			return 0, nil // Not enough data to parse
+		}
+		size, err := headers.ParseChunkSize(string(data[:lineEnd]))
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			r.state = requestStateParsingTrailers
+		} else {
+			r.remaining = size
+			r.state = requestStateParsingChunkData
 		}
+		return lineEnd + len(CRLF), nil
 
+	case requestStateParsingChunkData:
 		toAppend := data
-		if len(data) > remainingLength {
-			toAppend = data[:remainingLength]
+		if len(data) > r.remaining {
+			toAppend = data[:r.remaining]
 		}
-
-		r.Body = append(r.Body, toAppend...)
-		if len(r.Body) > contentLengthStr {
-			return 0, errors.New("body length exceeds Content-Length header")
+		r.appendBody(toAppend)
+		r.remaining -= len(toAppend)
+		if r.remaining == 0 {
+			r.state = requestStateParsingChunkEnd
 		}
+		return len(toAppend), nil
 
-		if len(r.Body) == contentLengthStr {
-			r.state = requestStateDone
+	case requestStateParsingChunkEnd:
+		if len(data) < len(CRLF) {
+			return 0, nil // Not enough data to parse
+		}
+		if string(data[:len(CRLF)]) != CRLF {
+			return 0, errors.New("missing CRLF after chunk data")
 		}
+		r.state = requestStateParsingChunkSize
+		return len(CRLF), nil
 
-		return len(toAppend), nil
+	case requestStateParsingTrailers:
+		// Trailer fields are read to keep the framing intact but are not exposed.
+		trailers := headers.NewHeaders()
+		parse := trailers.Parse
+		if r.strict {
+			parse = trailers.ParseStrict
+		}
+		bytesParsed, done, err := parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if !done {
+			return 0, nil // Not enough data to parse
+		}
+		r.state = requestStateDone
+		return bytesParsed + len(CRLF), nil
 
 	case requestStateDone:
 		return 0, errors.New("error: trying to read data in a done state")
@@ -126,23 +226,128 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
+// startBody picks the body framing from the request headers, applying the
+// rules of RFC 9112 section 6 strictly: any message a front-end proxy might
+// frame differently is rejected rather than guessed at.
+func (r *Request) startBody() error {
+	te, hasTE := r.Headers["transfer-encoding"]
+	cl, hasCL := r.Headers["content-length"]
+
+	if hasTE {
+		if hasCL {
+			return errors.New("both Transfer-Encoding and Content-Length present")
+		}
+		if r.RequestLine.HTTPVersion == "1.0" {
+			return errors.New("Transfer-Encoding in an HTTP/1.0 request")
+		}
+		if err := checkTransferCoding(te); err != nil {
+			return err
+		}
+		r.hasBody = true
+		r.state = requestStateParsingChunkSize
+		return nil
+	}
+
+	if hasCL {
+		contentLength, err := parseContentLength(cl)
+		if err != nil {
+			return err
+		}
+		if contentLength == 0 {
+			r.state = requestStateDone
+			return nil
+		}
+		r.hasBody = true
+		r.remaining = contentLength
+		r.state = requestStateParsingBody
+		return nil
+	}
+
+	r.state = requestStateDone
+	return nil
+}
+
+// checkTransferCoding accepts only a lone chunked coding, the only one this
+// parser can remove. Anything else must not be passed over silently.
+func checkTransferCoding(te string) error {
+	codings := strings.Split(te, ",")
+	for i, coding := range codings {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "chunked" {
+			return fmt.Errorf("%w: %q", ErrUnsupportedTransferCoding, coding)
+		}
+		if i != len(codings)-1 {
+			return errors.New("chunked must be the final transfer coding, applied once")
+		}
+	}
+	return nil
+}
+
+// parseContentLength parses a Content-Length value made only of digits.
+// Repeated fields, which arrive joined by commas, must all agree.
+func parseContentLength(value string) (int, error) {
+	length := -1
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return 0, fmt.Errorf("invalid Content-Length: %q", value)
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("invalid Content-Length: %q", value)
+		}
+		if length != -1 && n != length {
+			return 0, fmt.Errorf("conflicting Content-Length values: %q", value)
+		}
+		length = n
+	}
+	return length, nil
+}
+
+// appendBody stores decoded body bytes, either on Body or, for a streamed
+// request, in the buffer drained by the body reader.
+func (r *Request) appendBody(p []byte) {
+	if r.streaming {
+		r.pending = append(r.pending, p...)
+		return
+	}
+	r.Body = append(r.Body, p...)
+}
+
 // parseRequestLine parses the request line into a RequestLine struct.
-func parseRequestLine(requestLine string) (RequestLine, int, error) {
+func parseRequestLine(requestLine string, strict bool) (RequestLine, int, error) {
 	lineEnd := strings.Index(requestLine, CRLF)
 	if lineEnd == -1 {
 		return RequestLine{}, 0, nil // Not enough data
 	}
 
 	line := requestLine[:lineEnd]
+	if strings.ContainsAny(line, "\r\n") {
+		return RequestLine{}, lineEnd + len(CRLF), errors.New("bare CR or LF in request line")
+	}
 	parts := strings.Fields(line)
+	if strict {
+		parts = strings.Split(line, " ")
+	}
 	if len(parts) != 3 {
 		return RequestLine{}, lineEnd + len(CRLF), errors.New("invalid request line format")
 	}
+	if strict {
+		if !headers.IsToken(parts[0]) {
+			return RequestLine{}, lineEnd + len(CRLF), fmt.Errorf("invalid method %q", parts[0])
+		}
+		if !isTargetChars(parts[1]) {
+			return RequestLine{}, lineEnd + len(CRLF), fmt.Errorf("invalid character in request target %q", parts[1])
+		}
+	}
 
 	httpVersion := strings.TrimPrefix(parts[2], "HTTP/")
-	if httpVersion == parts[2] {
+	if httpVersion == parts[2] || !isVersionNumber(httpVersion) {
 		return RequestLine{}, lineEnd + len(CRLF), errors.New("invalid HTTP version format")
 	}
+	if httpVersion[0] != '1' {
+		return RequestLine{}, lineEnd + len(CRLF), fmt.Errorf("%w: HTTP/%s", ErrUnsupportedVersion, httpVersion)
+	}
 
 	return RequestLine{
 		Method:        parts[0],
@@ -151,38 +356,258 @@ func parseRequestLine(requestLine string) (RequestLine, int, error) {
 	}, lineEnd + len(CRLF), nil
 }
 
+// isVersionNumber reports whether v has the DIGIT "." DIGIT form of RFC 9112
+// section 2.3.
+func isVersionNumber(v string) bool {
+	return len(v) == 3 && v[1] == '.' && isDigit(v[0]) && isDigit(v[2])
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isTargetChars reports whether target is made only of characters a URI may
+// contain, with well-formed percent-encoding. Fragments are not allowed.
+func isTargetChars(target string) bool {
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		switch {
+		case c == '%':
+			if i+2 >= len(target) || !isHexDigit(target[i+1]) || !isHexDigit(target[i+2]) {
+				return false
+			}
+			i += 2
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', isDigit(c):
+		case strings.IndexByte("-._~!$&'()*+,;=:@/?[]", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// IsAbsoluteForm reports whether the request target is an absolute URI, as
+// sent by clients talking to a forward proxy.
+func (rl RequestLine) IsAbsoluteForm() bool {
+	return rl.Method != "CONNECT" && !strings.HasPrefix(rl.RequestTarget, "/") && rl.RequestTarget != "*"
+}
+
 // RequestFromReader reads and parses an HTTP request from an io.Reader.
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
+func RequestFromReader(reader io.Reader, opts ...Option) (*Request, error) {
 	req := &Request{state: requestStateInit}
+	for _, opt := range opts {
+		opt(req)
+	}
+	req.stream = newStreamReader(reader)
 
 	for req.state != requestStateDone {
-		if readToIndex == len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
-		}
-
-		n, err := reader.Read(buf[readToIndex:])
-		if err != nil {
+		if err := req.stream.readMore(req); err != nil {
 			if err == io.EOF {
 				req.state = requestStateDone
 				break
 			}
 			return nil, err
 		}
-		readToIndex += n
+	}
 
-		bytesParsed, err := req.parse(buf[:readToIndex])
-		if err != nil {
+	return req, nil
+}
+
+// RequestHeadFromReader reads and parses the request line and headers from an
+// io.Reader, leaving the body unread. The body can then be streamed with
+// BodyReader without holding it in memory.
+func RequestHeadFromReader(reader io.Reader, opts ...Option) (*Request, error) {
+	req := &Request{state: requestStateInit, streaming: true}
+	for _, opt := range opts {
+		opt(req)
+	}
+	req.stream = newStreamReader(reader)
+
+	for req.state == requestStateInit || req.state == requestStateParsingHeaders {
+		if err := req.stream.readMore(req); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
 			return nil, err
 		}
-		if bytesParsed > 0 {
-			copy(buf, buf[bytesParsed:readToIndex])
-			readToIndex -= bytesParsed
-		}
 	}
 
 	return req, nil
 }
+
+// BodyReader returns a reader over the decoded request body. For a request
+// read with RequestHeadFromReader the body is read from the connection as the
+// returned reader is consumed.
+func (r *Request) BodyReader() io.Reader {
+	if !r.streaming {
+		return bytes.NewReader(r.Body)
+	}
+	if r.decoder != nil {
+		return r.decoder
+	}
+	return &bodyReader{req: r}
+}
+
+// ReadBody reads the rest of a streamed body into Body.
+func (r *Request) ReadBody() error {
+	if !r.streaming {
+		return nil
+	}
+	if r.decoder != nil {
+		r.coded = bytes.NewBuffer(r.encoded)
+		defer func() { r.coded = nil }()
+	}
+	body, err := io.ReadAll(r.BodyReader())
+	if err != nil {
+		return err
+	}
+	r.Body = append(r.Body, body...)
+	if r.coded != nil {
+		r.encoded = r.coded.Bytes()
+	}
+	r.streaming = false
+	return nil
+}
+
+// EncodedBody returns the body as it was received, before
+// DecodeContentEncoding removed its content coding. It is Body if the body
+// was not decoded. A streamed body is only available once ReadBody has
+// read it.
+func (r *Request) EncodedBody() []byte {
+	if r.streaming {
+		return nil
+	}
+	if r.encoded != nil {
+		return r.encoded
+	}
+	return r.Body
+}
+
+// Buffered returns the bytes that were read from the underlying reader but
+// not consumed by the parser, such as data a client sent straight after the
+// request. Once the body has been read these belong to whatever follows the
+// request on the connection.
+func (r *Request) Buffered() []byte {
+	if r.stream == nil {
+		return nil
+	}
+	return bytes.Clone(r.stream.buf[:r.stream.readToIndex])
+}
+
+// KeepAlive reports whether the client is willing to send another request
+// on the connection: by default for HTTP/1.1, and only when asked with
+// "Connection: keep-alive" for HTTP/1.0.
+func (r *Request) KeepAlive() bool {
+	connection := r.Headers["connection"]
+	if headers.HasToken(connection, "close") {
+		return false
+	}
+	return r.RequestLine.HTTPVersion != "1.0" || headers.HasToken(connection, "keep-alive")
+}
+
+// DiscardBody reads and drops whatever is left of a streamed body, so that
+// the connection is positioned at the next request. It gives up with
+// ErrDiscardLimit after 256KB, and the connection should then be closed.
+func (r *Request) DiscardBody() error {
+	if !r.streaming {
+		return nil
+	}
+	_, err := io.CopyN(io.Discard, &bodyReader{req: r}, maxDiscard+1)
+	switch err {
+	case io.EOF:
+		return nil
+	case nil:
+		return ErrDiscardLimit
+	}
+	return err
+}
+
+// Cookies returns the cookies sent in the Cookie header.
+func (r *Request) Cookies() []cookie.Cookie {
+	return cookie.Parse(r.Headers["cookie"])
+}
+
+// Cookie returns the value of the cookie called name.
+func (r *Request) Cookie(name string) (string, bool) {
+	return cookie.Get(r.Headers["cookie"], name)
+}
+
+// BodyRead reports whether the whole body has been read from the
+// connection, so that whatever the client sends next is not part of it.
+func (r *Request) BodyRead() bool {
+	return !r.streaming || r.state == requestStateDone
+}
+
+// HasBody reports whether the request carries a message body.
+func (r *Request) HasBody() bool {
+	return r.hasBody || len(r.Body) > 0
+}
+
+// streamReader feeds bytes from an io.Reader into a Request's parser,
+// keeping any bytes that have been read but not yet parsed.
+type streamReader struct {
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+}
+
+func newStreamReader(reader io.Reader) *streamReader {
+	return &streamReader{reader: reader, buf: make([]byte, bufferSize)}
+}
+
+// readMore performs a single read from the underlying reader and parses as
+// much of the buffered data as possible.
+func (s *streamReader) readMore(req *Request) error {
+	if s.readToIndex == len(s.buf) {
+		newBuf := make([]byte, len(s.buf)*2)
+		copy(newBuf, s.buf)
+		s.buf = newBuf
+	}
+
+	n, err := s.reader.Read(s.buf[s.readToIndex:])
+	if err != nil {
+		return err
+	}
+	s.readToIndex += n
+
+	bytesParsed, err := req.parse(s.buf[:s.readToIndex])
+	if err != nil {
+		return err
+	}
+	if bytesParsed > 0 {
+		copy(s.buf, s.buf[bytesParsed:s.readToIndex])
+		s.readToIndex -= bytesParsed
+	}
+	return nil
+}
+
+// bodyReader streams the decoded body of a request read with RequestHeadFromReader.
+type bodyReader struct {
+	req *Request
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	r := b.req
+	for len(r.pending) == 0 {
+		if r.state == requestStateDone {
+			return 0, io.EOF
+		}
+		if err := r.stream.readMore(r); err != nil {
+			if err == io.EOF {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	if r.coded != nil {
+		r.coded.Write(p[:n])
+	}
+	return n, nil
+}
@@ -1,6 +1,7 @@
 package request
 
 import (
+	"fmt"
 	"io"
 	"strings"
 	"testing"
@@ -192,3 +193,199 @@ func TestRequestFromReader(t *testing.T) {
 	// require.Error(t, err)
 	// require.Nil(t, r)
 }
+
+func TestRequestBodyStreaming(t *testing.T) {
+	// Test: Chunked body read in full
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n" +
+			"6;ext=1\r\nworld!\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(r.Body))
+
+	// Test: Head only, body streamed afterwards
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "POST", r.RequestLine.Method)
+	assert.True(t, r.HasBody())
+	assert.Empty(t, r.Body)
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+
+	// Test: Streamed chunked body
+	reader = &chunkReader{
+		data: "PUT /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nabc\r\n" +
+			"3\r\ndef\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, "abcdef", string(r.Body))
+
+	// Test: Streamed body cut short
+	reader = &chunkReader{
+		data:            "POST /upload HTTP/1.1\r\nContent-Length: 20\r\n\r\npartial content",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestHeadFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: A small unread body is discarded
+	r, err = RequestHeadFromReader(strings.NewReader("POST /upload HTTP/1.1\r\nContent-Length: 5\r\n\r\nhelloGET"))
+	require.NoError(t, err)
+	require.NoError(t, r.DiscardBody())
+	assert.Equal(t, "GET", string(r.Buffered()))
+
+	// Test: A large unread body is not drained
+	big := strings.Repeat("x", maxDiscard+10)
+	r, err = RequestHeadFromReader(strings.NewReader(fmt.Sprintf("POST /upload HTTP/1.1\r\nContent-Length: %d\r\n\r\n%s", len(big), big)))
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.DiscardBody(), ErrDiscardLimit)
+
+	// Test: No body
+	r, err = RequestHeadFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.HasBody())
+	body, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	// Test: Invalid chunk size
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nxyz\r\n"))
+	require.Error(t, err)
+}
+
+func TestRequestTargetForms(t *testing.T) {
+	// Test: Absolute-form
+	r, err := RequestFromReader(strings.NewReader("GET http://example.com/path?x=1 HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/path?x=1", r.RequestLine.RequestTarget)
+	assert.True(t, r.RequestLine.IsAbsoluteForm())
+
+	// Test: Authority-form for CONNECT
+	r, err = RequestFromReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:443", r.RequestLine.RequestTarget)
+	assert.False(t, r.RequestLine.IsAbsoluteForm())
+
+	// Test: CONNECT without a port
+	_, err = RequestFromReader(strings.NewReader("CONNECT example.com HTTP/1.1\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Asterisk-form for OPTIONS only
+	_, err = RequestFromReader(strings.NewReader("OPTIONS * HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = RequestFromReader(strings.NewReader("GET * HTTP/1.1\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Relative target without a leading slash
+	_, err = RequestFromReader(strings.NewReader("GET coffee HTTP/1.1\r\n\r\n"))
+	require.Error(t, err)
+}
+
+func TestRequestVersions(t *testing.T) {
+	// Test: HTTP/1.0 only keeps the connection open when asked
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HTTPVersion)
+	assert.False(t, r.KeepAlive())
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: HTTP/1.1 keeps it open unless told to close
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nConnection: keep-alive, close\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+
+	// Test: Other major versions are well-formed but unsupported
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/3.0\r\n\r\n"))
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	// Test: Malformed versions
+	for _, version := range []string{"HTTP/1", "HTTP/1.10", "HTTP/x.y", "http/1.1"} {
+		_, err = RequestFromReader(strings.NewReader("GET / " + version + "\r\n\r\n"))
+		require.Error(t, err, version)
+		require.NotErrorIs(t, err, ErrUnsupportedVersion, version)
+	}
+}
+
+func TestStrictParsing(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"tab between parts", "GET\t/ HTTP/1.1\r\nHost: a\r\n\r\n"},
+		{"double space", "GET  / HTTP/1.1\r\nHost: a\r\n\r\n"},
+		{"trailing space", "GET / HTTP/1.1 \r\nHost: a\r\n\r\n"},
+		{"method with separator", "GE(T / HTTP/1.1\r\nHost: a\r\n\r\n"},
+		{"fragment in target", "GET /page#top HTTP/1.1\r\nHost: a\r\n\r\n"},
+		{"raw non-ASCII in target", "GET /caf\xc3\xa9 HTTP/1.1\r\nHost: a\r\n\r\n"},
+		{"control character in value", "GET / HTTP/1.1\r\nHost: a\r\nX-Test: a\x01b\r\n\r\n"},
+		{"obsolete line folding", "GET / HTTP/1.1\r\nHost: a\r\nX-Test: one\r\n two\r\n\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Test: Rejected in strict mode but accepted by default
+			_, err := RequestFromReader(strings.NewReader(tt.raw), Strict())
+			require.Error(t, err)
+			_, err = RequestFromReader(strings.NewReader(tt.raw))
+			require.NoError(t, err)
+		})
+	}
+
+	// Test: Lenient mode unfolds obsolete line folding
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\nX-Test: one\r\n \t two\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "one two", r.Headers["x-test"])
+
+	// Test: NUL is rejected in either mode
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\nX-Test: a\x00b\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Well-formed request passes strict mode
+	r, err = RequestFromReader(strings.NewReader("GET /a%20b?q=1&r=[x] HTTP/1.1\r\nHost: a\r\nX-Test: tab\tinside\r\n\r\n"), Strict())
+	require.NoError(t, err)
+	assert.Equal(t, "/a%20b?q=1&r=[x]", r.RequestLine.RequestTarget)
+}
+
+func TestRequestCookies(t *testing.T) {
+	// Test: Cookies from one or more Cookie headers
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\nCookie: a=1; b=\"two\"\r\nCookie: c=3\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Len(t, r.Cookies(), 3)
+	value, ok := r.Cookie("b")
+	assert.True(t, ok)
+	assert.Equal(t, "two", value)
+	_, ok = r.Cookie("missing")
+	assert.False(t, ok)
+}
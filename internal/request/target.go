@@ -0,0 +1,161 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// TargetForm is the form of a request target, RFC 9112 section 3.2.
+type TargetForm int
+
+const (
+	// OriginForm is an absolute path with an optional query: "/a/b?x=1".
+	OriginForm TargetForm = iota
+	// AbsoluteForm is a complete URI, as sent to forward proxies.
+	AbsoluteForm
+	// AuthorityForm is the host and port of a CONNECT request.
+	AuthorityForm
+	// AsteriskForm is the "*" of a server-wide OPTIONS request.
+	AsteriskForm
+)
+
+// ErrInvalidEscape is returned for a request target or form with a "%" not
+// followed by two hex digits.
+var ErrInvalidEscape = errors.New("invalid percent-encoding")
+
+// Target is the parsed request target.
+type Target struct {
+	Form TargetForm
+	// Scheme and Host are set for the absolute form; Host alone for the
+	// authority form.
+	Scheme string
+	Host   string
+	// Path is the percent-decoded path with "." and ".." segments removed.
+	// It always starts with "/" for the origin and absolute forms and is
+	// empty for the others.
+	Path string
+	// RawPath is the path exactly as sent.
+	RawPath string
+	// RawQuery is the query without the "?", still encoded.
+	RawQuery string
+	query    url.Values
+}
+
+// Query returns the decoded query parameters. A name may have several values,
+// in the order they were sent.
+func (t Target) Query() url.Values {
+	if t.query == nil {
+		return url.Values{}
+	}
+	return t.query
+}
+
+// ParseTarget parses target as sent with method, checking that its form is
+// allowed for the method: authority-form for CONNECT, asterisk-form for
+// OPTIONS, and origin-form or absolute-form otherwise.
+func ParseTarget(method, target string) (Target, error) {
+	if method == "CONNECT" {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || host == "" || port == "" {
+			return Target{}, errors.New("invalid CONNECT request target")
+		}
+		return Target{Form: AuthorityForm, Host: target}, nil
+	}
+	if target == "*" {
+		if method != "OPTIONS" {
+			return Target{}, errors.New("asterisk request target is only valid for OPTIONS")
+		}
+		return Target{Form: AsteriskForm}, nil
+	}
+
+	t := Target{Form: OriginForm}
+	rest := target
+	if !strings.HasPrefix(target, "/") {
+		u, err := url.Parse(target)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return Target{}, errors.New("invalid request target")
+		}
+		t.Form = AbsoluteForm
+		t.Scheme = strings.ToLower(u.Scheme)
+		t.Host = u.Host
+		// Take the path from the target itself so it is not re-encoded.
+		rest = rest[strings.Index(rest, "//")+2:]
+		if i := strings.IndexAny(rest, "/?"); i >= 0 {
+			rest = rest[i:]
+		} else {
+			rest = ""
+		}
+	}
+	if i := strings.IndexByte(rest, '#'); i >= 0 {
+		rest = rest[:i]
+	}
+	t.RawPath, t.RawQuery, _ = strings.Cut(rest, "?")
+	if t.RawPath == "" {
+		t.RawPath = "/"
+	}
+
+	var err error
+	if t.Path, err = cleanPath(t.RawPath); err != nil {
+		return Target{}, err
+	}
+	if t.query, err = parseQuery(t.RawQuery); err != nil {
+		return Target{}, err
+	}
+	return t, nil
+}
+
+// cleanPath decodes raw and removes dot segments as in RFC 3986 section
+// 5.2.4, never climbing above the root. The path is decoded first, so that
+// "%2e%2e" is removed like ".." and "%2f" separates segments like "/": a
+// decoded ".." can never survive into the result.
+func cleanPath(raw string) (string, error) {
+	decoded, err := url.PathUnescape(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidEscape, raw)
+	}
+	segments := strings.Split(decoded[1:], "/")
+	cleaned := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+		case "..":
+			if len(cleaned) > 0 {
+				cleaned = cleaned[:len(cleaned)-1]
+			}
+		default:
+			cleaned = append(cleaned, segment)
+			continue
+		}
+		// A trailing dot segment leaves the path ending in "/".
+		if last {
+			cleaned = append(cleaned, "")
+		}
+	}
+	return "/" + strings.Join(cleaned, "/"), nil
+}
+
+// parseQuery decodes an application/x-www-form-urlencoded query. Unlike
+// url.ParseQuery it accepts ";" in values, which many clients send unencoded.
+func parseQuery(raw string) (url.Values, error) {
+	values := url.Values{}
+	for _, pair := range strings.Split(raw, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidEscape, raw)
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidEscape, raw)
+		}
+		values[name] = append(values[name], value)
+	}
+	return values, nil
+}
@@ -0,0 +1,83 @@
+package request
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTarget(t *testing.T) {
+	// Test: Origin form with a query
+	target, err := ParseTarget("GET", "/httpbin/get?x=1&x=2&name=J%C3%B6rg+M&flag")
+	require.NoError(t, err)
+	assert.Equal(t, OriginForm, target.Form)
+	assert.Equal(t, "/httpbin/get", target.Path)
+	assert.Equal(t, "x=1&x=2&name=J%C3%B6rg+M&flag", target.RawQuery)
+	assert.Equal(t, url.Values{"x": {"1", "2"}, "name": {"Jörg M"}, "flag": {""}}, target.Query())
+
+	// Test: Path is decoded and dot segments are removed
+	for raw, want := range map[string]string{
+		"/a/b/../c":   "/a/c",
+		"/a/./b/":     "/a/b/",
+		"/a/b/..":     "/a/",
+		"/../../etc":  "/etc",
+		"/a/%2e%2e/b": "/b",
+		// Encoded slashes cannot hide dot segments
+		"/a/..%2f..%2fetc/passwd": "/etc/passwd",
+		"/a/b%2F..%2F..%2Fc":      "/c",
+		"/caf%C3%A9%20bar":        "/café bar",
+		"/a+b;c":                  "/a+b;c",
+	} {
+		target, err := ParseTarget("GET", raw)
+		require.NoError(t, err, raw)
+		assert.Equal(t, want, target.Path, raw)
+		assert.Equal(t, raw, target.RawPath)
+	}
+
+	// Test: Absolute form keeps the path as sent
+	target, err = ParseTarget("GET", "HTTP://example.com:8080/a%20b?q=1")
+	require.NoError(t, err)
+	assert.Equal(t, AbsoluteForm, target.Form)
+	assert.Equal(t, "http", target.Scheme)
+	assert.Equal(t, "example.com:8080", target.Host)
+	assert.Equal(t, "/a%20b", target.RawPath)
+	assert.Equal(t, "/a b", target.Path)
+	assert.Equal(t, []string{"1"}, target.Query()["q"])
+
+	// Test: Absolute form without a path
+	target, err = ParseTarget("GET", "http://example.com?q=1")
+	require.NoError(t, err)
+	assert.Equal(t, "/", target.Path)
+	assert.Equal(t, "q=1", target.RawQuery)
+
+	// Test: Authority and asterisk forms
+	target, err = ParseTarget("CONNECT", "example.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, Target{Form: AuthorityForm, Host: "example.com:443"}, target)
+	target, err = ParseTarget("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, AsteriskForm, target.Form)
+	assert.Empty(t, target.Query())
+
+	// Test: Malformed percent-encoding in the path or query
+	for _, raw := range []string{"/a%2", "/a%zz", "/?q=%", "/?%g1=x"} {
+		_, err := ParseTarget("GET", raw)
+		assert.ErrorIs(t, err, ErrInvalidEscape, raw)
+	}
+}
+
+func TestRequestTarget(t *testing.T) {
+	// Test: Parsed target is available on the request
+	r, err := RequestFromReader(strings.NewReader("GET /search/../find?q=go+http HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/find", r.Target.Path)
+	assert.Equal(t, "go http", r.Target.Query().Get("q"))
+	assert.Equal(t, "/search/../find?q=go+http", r.RequestLine.RequestTarget)
+
+	// Test: Malformed percent-encoding fails the request
+	_, err = RequestFromReader(strings.NewReader("GET /%zz HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidEscape)
+}
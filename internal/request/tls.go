@@ -0,0 +1,72 @@
+package request
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+)
+
+// TLSInfo describes the TLS connection a request arrived on.
+type TLSInfo struct {
+	// Version and CipherSuite are the tls package's constants, such as
+	// tls.VersionTLS13.
+	Version     uint16
+	CipherSuite uint16
+	// ServerName is the name the client asked for with SNI, if any.
+	ServerName string
+	// Protocol is the protocol agreed with ALPN, such as "h2", if any.
+	Protocol string
+	// Peer is the identity of the client, or nil if it sent no certificate
+	// or its certificate was not verified.
+	Peer *PeerIdentity
+}
+
+// PeerIdentity is the identity in a client certificate that was verified
+// against the server's trusted CAs.
+type PeerIdentity struct {
+	// Subject is the distinguished name, such as "CN=alice,O=Example".
+	Subject        string
+	CommonName     string
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []string
+	// Fingerprint is the SHA-256 of the certificate in lowercase hex.
+	Fingerprint string
+	// Certificate is the client's leaf certificate.
+	Certificate *x509.Certificate
+}
+
+// NewTLSInfo describes the connection in state. Peer is only set when the
+// client certificate was verified.
+func NewTLSInfo(state tls.ConnectionState) *TLSInfo {
+	info := &TLSInfo{
+		Version:     state.Version,
+		CipherSuite: state.CipherSuite,
+		ServerName:  state.ServerName,
+		Protocol:    state.NegotiatedProtocol,
+	}
+	if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		info.Peer = newPeerIdentity(state.VerifiedChains[0][0])
+	}
+	return info
+}
+
+func newPeerIdentity(cert *x509.Certificate) *PeerIdentity {
+	sum := sha256.Sum256(cert.Raw)
+	peer := &PeerIdentity{
+		Subject:        cert.Subject.String(),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		Fingerprint:    hex.EncodeToString(sum[:]),
+		Certificate:    cert,
+	}
+	for _, uri := range cert.URIs {
+		peer.URIs = append(peer.URIs, uri.String())
+	}
+	return peer
+}
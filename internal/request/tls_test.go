@@ -0,0 +1,57 @@
+package request
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTLSInfo(t *testing.T) {
+	spiffe, err := url.Parse("spiffe://example.com/billing")
+	require.NoError(t, err)
+	cert := &x509.Certificate{
+		Raw:            []byte("certificate bytes"),
+		Subject:        pkix.Name{CommonName: "alice", Organization: []string{"Example"}},
+		DNSNames:       []string{"alice.example.com"},
+		EmailAddresses: []string{"alice@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{spiffe},
+	}
+	state := tls.ConnectionState{
+		Version:            tls.VersionTLS13,
+		CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+		ServerName:         "api.example.com",
+		NegotiatedProtocol: "h2",
+		PeerCertificates:   []*x509.Certificate{cert},
+	}
+
+	// Test: Connection details without a verified certificate
+	info := NewTLSInfo(state)
+	assert.Equal(t, uint16(tls.VersionTLS13), info.Version)
+	assert.Equal(t, tls.TLS_AES_128_GCM_SHA256, info.CipherSuite)
+	assert.Equal(t, "api.example.com", info.ServerName)
+	assert.Equal(t, "h2", info.Protocol)
+	assert.Nil(t, info.Peer)
+
+	// Test: The identity in a verified certificate
+	state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	peer := NewTLSInfo(state).Peer
+	require.NotNil(t, peer)
+	sum := sha256.Sum256(cert.Raw)
+	assert.Equal(t, "CN=alice,O=Example", peer.Subject)
+	assert.Equal(t, "alice", peer.CommonName)
+	assert.Equal(t, []string{"alice.example.com"}, peer.DNSNames)
+	assert.Equal(t, []string{"alice@example.com"}, peer.EmailAddresses)
+	assert.Equal(t, "10.0.0.1", peer.IPAddresses[0].String())
+	assert.Equal(t, []string{"spiffe://example.com/billing"}, peer.URIs)
+	assert.Equal(t, hex.EncodeToString(sum[:]), peer.Fingerprint)
+	assert.Same(t, cert, peer.Certificate)
+}
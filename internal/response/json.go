@@ -0,0 +1,83 @@
+package response
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"httpfromtcp/internal/headers"
+)
+
+// WriteJSON writes a complete response whose body is v encoded as JSON.
+func (w *Writer) WriteJSON(statusCode StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.writeComplete(statusCode, "application/json", body, nil)
+}
+
+// Problem is an RFC 9457 problem details object.
+type Problem struct {
+	// Type is a URI identifying the kind of problem. Empty means
+	// "about:blank": the problem is described by the status code alone.
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Extensions are additional members. They cannot replace the ones above.
+	Extensions map[string]any `json:"-"`
+}
+
+// MarshalJSON encodes the standard members together with the extensions.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type plain Problem
+	standard, err := json.Marshal(plain(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return standard, err
+	}
+	members := map[string]any{}
+	for name, value := range p.Extensions {
+		members[name] = value
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(standard, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range fields {
+		members[name] = value
+	}
+	return json.Marshal(members)
+}
+
+// WriteProblem writes p as an application/problem+json response with
+// p.Status as the status code, or 500 if it is not set. h holds any extra
+// headers.
+func (w *Writer) WriteProblem(p Problem, h headers.Headers) error {
+	if p.Status == 0 {
+		p.Status = int(StatusCodeServerError)
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return w.writeComplete(StatusCode(p.Status), "application/problem+json", body, h)
+}
+
+// writeComplete writes a response with a body known in advance.
+func (w *Writer) writeComplete(statusCode StatusCode, contentType string, body []byte, extra headers.Headers) error {
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	h := headers.NewHeaders()
+	for name, value := range extra {
+		h.Set(name, value)
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	_, err := w.WriteBody(body)
+	return err
+}
@@ -0,0 +1,71 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"httpfromtcp/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteJSON(t *testing.T) {
+	// Test: Body, Content-Type and Content-Length
+	var buf bytes.Buffer
+	w := &Writer{Writer: &buf}
+	require.NoError(t, w.WriteJSON(201, map[string]any{"id": 7, "name": "box"}))
+	res, err := ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(201), res.StatusLine.StatusCode)
+	assert.Equal(t, "application/json", res.Headers.Get("Content-Type"))
+	assert.Equal(t, "21", res.Headers.Get("Content-Length"))
+	assert.JSONEq(t, `{"id":7,"name":"box"}`, string(res.Body))
+
+	// Test: A value that cannot be encoded writes nothing
+	buf.Reset()
+	w = &Writer{Writer: &buf}
+	require.Error(t, w.WriteJSON(200, func() {}))
+	assert.Empty(t, buf.String())
+}
+
+func TestWriteProblem(t *testing.T) {
+	// Test: Standard members, extensions and extra headers
+	var buf bytes.Buffer
+	w := &Writer{Writer: &buf}
+	err := w.WriteProblem(Problem{
+		Type:       "https://example.com/probs/out-of-credit",
+		Title:      "You do not have enough credit.",
+		Status:     403,
+		Detail:     "Your current balance is 30, but that costs 50.",
+		Instance:   "/account/12345/msgs/abc",
+		Extensions: map[string]any{"balance": 30, "status": "ignored"},
+	}, headers.Headers{"Retry-After": "60"})
+	require.NoError(t, err)
+	res, err := ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(403), res.StatusLine.StatusCode)
+	assert.Equal(t, "application/problem+json", res.Headers.Get("Content-Type"))
+	assert.Equal(t, "60", res.Headers.Get("Retry-After"))
+	assert.JSONEq(t, `{
+		"type": "https://example.com/probs/out-of-credit",
+		"title": "You do not have enough credit.",
+		"status": 403,
+		"detail": "Your current balance is 30, but that costs 50.",
+		"instance": "/account/12345/msgs/abc",
+		"balance": 30
+	}`, string(res.Body))
+
+	// Test: Status defaults to 500 and empty members are omitted
+	encoded, err := json.Marshal(Problem{Title: "Oops"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"title":"Oops"}`, string(encoded))
+	buf.Reset()
+	w = &Writer{Writer: &buf}
+	require.NoError(t, w.WriteProblem(Problem{}, nil))
+	res, err = ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeServerError, res.StatusLine.StatusCode)
+	assert.JSONEq(t, `{"status":500}`, string(res.Body))
+}
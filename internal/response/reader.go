@@ -0,0 +1,289 @@
+package response
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+)
+
+const (
+	CRLF       = "\r\n"
+	bufferSize = 8
+)
+
+// Response represents an HTTP response read from a connection.
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	Body       []byte
+	Trailers   headers.Headers
+	state      responseState
+	remaining  int
+}
+
+type responseState int
+
+const (
+	responseStateInit responseState = iota
+	responseStateParsingHeaders
+	responseStateParsingBody
+	responseStateParsingChunkSize
+	responseStateParsingChunkData
+	responseStateParsingChunkEnd
+	responseStateParsingTrailers
+	responseStateParsingUntilClose
+	responseStateDone
+)
+
+// StatusLine represents the components of an HTTP status line.
+type StatusLine struct {
+	HTTPVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+// parse processes the input data and updates the Response state.
+func (r *Response) parse(data []byte) (int, error) {
+	totalBytesParsed := 0
+	for r.state != responseStateDone {
+		bytesParsed, err := r.parseSingle(data[totalBytesParsed:])
+		if err != nil {
+			return totalBytesParsed, err
+		}
+		if bytesParsed == 0 {
+			break // Not enough data to parse
+		}
+		totalBytesParsed += bytesParsed
+		if totalBytesParsed >= len(data) {
+			break // All data has been parsed
+		}
+	}
+	return totalBytesParsed, nil
+}
+
+// parseSingle processes a single step of the response parsing based on the current state.
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.state {
+	case responseStateInit:
+		statusLine, bytesParsed, err := parseStatusLine(string(data))
+		if err != nil {
+			return 0, err
+		}
+		if bytesParsed == 0 {
+			return 0, nil // Not enough data to parse
+		}
+		r.StatusLine = statusLine
+		r.state = responseStateParsingHeaders
+		return bytesParsed, nil
+
+	case responseStateParsingHeaders:
+		headersMap := headers.NewHeaders()
+		bytesParsed, done, err := headersMap.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if !done {
+			return 0, nil // Not enough data to parse
+		}
+		r.Headers = headersMap
+		if err := r.startBody(); err != nil {
+			return 0, err
+		}
+		return bytesParsed + len(CRLF), nil
+
+	case responseStateParsingBody:
+		toAppend := data
+		if len(data) > r.remaining {
+			toAppend = data[:r.remaining]
+		}
+		r.Body = append(r.Body, toAppend...)
+		r.remaining -= len(toAppend)
+		if r.remaining == 0 {
+			r.state = responseStateDone
+		}
+		return len(toAppend), nil
+
+	case responseStateParsingChunkSize:
+		lineEnd := strings.Index(string(data), CRLF)
+		if lineEnd == -1 {
+			return 0, nil // Not enough data to parse
+		}
+		size, err := headers.ParseChunkSize(string(data[:lineEnd]))
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			r.state = responseStateParsingTrailers
+		} else {
+			r.remaining = size
+			r.state = responseStateParsingChunkData
+		}
+		return lineEnd + len(CRLF), nil
+
+	case responseStateParsingChunkData:
+		toAppend := data
+		if len(data) > r.remaining {
+			toAppend = data[:r.remaining]
+		}
+		r.Body = append(r.Body, toAppend...)
+		r.remaining -= len(toAppend)
+		if r.remaining == 0 {
+			r.state = responseStateParsingChunkEnd
+		}
+		return len(toAppend), nil
+
+	case responseStateParsingChunkEnd:
+		if len(data) < len(CRLF) {
+			return 0, nil // Not enough data to parse
+		}
+		if string(data[:len(CRLF)]) != CRLF {
+			return 0, errors.New("missing CRLF after chunk data")
+		}
+		r.state = responseStateParsingChunkSize
+		return len(CRLF), nil
+
+	case responseStateParsingTrailers:
+		trailers := headers.NewHeaders()
+		bytesParsed, done, err := trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if !done {
+			return 0, nil // Not enough data to parse
+		}
+		r.Trailers = trailers
+		r.state = responseStateDone
+		return bytesParsed + len(CRLF), nil
+
+	case responseStateParsingUntilClose:
+		r.Body = append(r.Body, data...)
+		return len(data), nil
+
+	case responseStateDone:
+		return 0, errors.New("error: trying to read data in a done state")
+
+	default:
+		return 0, errors.New("error: unknown state")
+	}
+}
+
+// startBody picks the body framing from the response headers (RFC 9112 section 6.3).
+func (r *Response) startBody() error {
+	code := r.StatusLine.StatusCode
+	if (code >= 100 && code < 200) || code == 204 || code == 304 {
+		r.state = responseStateDone
+		return nil
+	}
+
+	if te := r.Headers.Get("Transfer-Encoding"); te != "" {
+		codings := strings.Split(te, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			r.state = responseStateParsingUntilClose
+			return nil
+		}
+		r.state = responseStateParsingChunkSize
+		return nil
+	}
+
+	if cl := r.Headers.Get("Content-Length"); cl != "" {
+		contentLength, err := strconv.Atoi(cl)
+		if err != nil || contentLength < 0 {
+			return errors.New("invalid Content-Length header")
+		}
+		if contentLength == 0 {
+			r.state = responseStateDone
+			return nil
+		}
+		r.remaining = contentLength
+		r.state = responseStateParsingBody
+		return nil
+	}
+
+	r.state = responseStateParsingUntilClose
+	return nil
+}
+
+// parseStatusLine parses the status line into a StatusLine struct.
+func parseStatusLine(statusLine string) (StatusLine, int, error) {
+	lineEnd := strings.Index(statusLine, CRLF)
+	if lineEnd == -1 {
+		return StatusLine{}, 0, nil // Not enough data
+	}
+
+	// The reason phrase may contain spaces or be empty, so only split twice.
+	line := statusLine[:lineEnd]
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return StatusLine{}, lineEnd + len(CRLF), errors.New("invalid status line format")
+	}
+
+	httpVersion := strings.TrimPrefix(parts[0], "HTTP/")
+	if httpVersion == parts[0] {
+		return StatusLine{}, lineEnd + len(CRLF), errors.New("invalid HTTP version format")
+	}
+
+	if len(parts[1]) != 3 {
+		return StatusLine{}, lineEnd + len(CRLF), errors.New("invalid status code")
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || code < 100 {
+		return StatusLine{}, lineEnd + len(CRLF), errors.New("invalid status code")
+	}
+
+	reason := ""
+	if len(parts) == 3 {
+		reason = parts[2]
+	}
+
+	return StatusLine{
+		HTTPVersion:  httpVersion,
+		StatusCode:   StatusCode(code),
+		ReasonPhrase: reason,
+	}, lineEnd + len(CRLF), nil
+}
+
+// ResponseFromReader reads and parses an HTTP response from an io.Reader.
+func ResponseFromReader(reader io.Reader) (*Response, error) {
+	buf := make([]byte, bufferSize)
+	readToIndex := 0
+	res := &Response{state: responseStateInit}
+
+	for res.state != responseStateDone {
+		if readToIndex == len(buf) {
+			newBuf := make([]byte, len(buf)*2)
+			copy(newBuf, buf)
+			buf = newBuf
+		}
+
+		n, err := reader.Read(buf[readToIndex:])
+		readToIndex += n
+		if n > 0 {
+			bytesParsed, parseErr := res.parse(buf[:readToIndex])
+			if parseErr != nil {
+				return nil, parseErr
+			}
+			if bytesParsed > 0 {
+				copy(buf, buf[bytesParsed:readToIndex])
+				readToIndex -= bytesParsed
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				if res.state == responseStateParsingUntilClose {
+					res.state = responseStateDone
+					break
+				}
+				if res.state != responseStateDone {
+					return nil, io.ErrUnexpectedEOF
+				}
+				break
+			}
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
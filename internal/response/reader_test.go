@@ -0,0 +1,154 @@
+package response
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+	return n, nil
+}
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Content-Length body
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "1.1", r.StatusLine.HTTPVersion)
+	assert.Equal(t, StatusCodeOk, r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "text/plain", r.Headers.Get("Content-Type"))
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: Reason phrase with spaces
+	reader = &chunkReader{
+		data:            "HTTP/1.1 500 Internal Server Error\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeServerError, r.StatusLine.StatusCode)
+	assert.Equal(t, "Internal Server Error", r.StatusLine.ReasonPhrase)
+	assert.Empty(t, r.Body)
+
+	// Test: Obsolete line folding from older servers is unfolded
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nX-Long: one\r\n two\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "one two", r.Headers.Get("X-Long"))
+
+	// Test: Empty reason phrase
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 999 \r\nContent-Length: 0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(999), r.StatusLine.StatusCode)
+	assert.Equal(t, "", r.StatusLine.ReasonPhrase)
+
+	// Test: Chunked body with trailers
+	reader = &chunkReader{
+		data: "HTTP/1.1 200 OK\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Content-Length\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n" +
+			"6;ext=1\r\nworld!\r\n" +
+			"0\r\n" +
+			"X-Content-Length: 12\r\n" +
+			"\r\n",
+		numBytesPerRead: 2,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(r.Body))
+	assert.Equal(t, "12", r.Trailers.Get("X-Content-Length"))
+
+	// Test: Chunked body without trailers
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\na\r\n0123456789\r\n0\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(r.Body))
+	assert.Empty(t, r.Trailers)
+
+	// Test: Body delimited by connection close
+	reader = &chunkReader{
+		data:            "HTTP/1.0 200 OK\r\nContent-Type: text/html\r\n\r\n<html></html>",
+		numBytesPerRead: 4,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.StatusLine.HTTPVersion)
+	assert.Equal(t, "<html></html>", string(r.Body))
+
+	// Test: No body for 204
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 204 No Content\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(204), r.StatusLine.StatusCode)
+	assert.Empty(t, r.Body)
+
+	// Test: Body shorter than Content-Length
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 20\r\n\r\npartial"))
+	require.Error(t, err)
+	require.Nil(t, r)
+
+	// Test: Truncated chunked body
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel"))
+	require.Error(t, err)
+	require.Nil(t, r)
+
+	// Test: Invalid chunk size
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"))
+	require.Error(t, err)
+	require.Nil(t, r)
+
+	// Test: Signed and padded chunk sizes are rejected like on requests
+	for _, size := range []string{"+5", " 5", "5 ", "0x5"} {
+		r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" + size + "\r\nhello\r\n0\r\n\r\n"))
+		require.Error(t, err, size)
+		require.Nil(t, r)
+	}
+
+	// Test: Invalid status line
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1\r\n\r\n"))
+	require.Error(t, err)
+	require.Nil(t, r)
+
+	// Test: Invalid status code
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 2000 OK\r\n\r\n"))
+	require.Error(t, err)
+	require.Nil(t, r)
+
+	// Test: Missing HTTP version prefix
+	r, err = ResponseFromReader(strings.NewReader("1.1 200 OK\r\n\r\n"))
+	require.Error(t, err)
+	require.Nil(t, r)
+}
@@ -1,15 +1,36 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"httpfromtcp/internal/headers"
 	"io"
+	"maps"
+	"net"
+	"net/http"
 	"strconv"
+	"strings"
 )
 
 type Writer struct {
 	io.Writer
-	State WriterState
+	State      WriterState
+	StatusCode StatusCode
+	// Version is the HTTP/1.x version sent in the status line, "1.1" if
+	// empty. HTTP/1.0 has no chunked coding, so such bodies are delimited by
+	// closing the connection instead.
+	Version string
+	// KeepAlive is set by the server when the client may send another
+	// request on the connection. The Writer clears it if the response can
+	// only end by closing the connection.
+	KeepAlive bool
+	// RequestHeaders are the headers of the request being answered, for
+	// responses negotiated on behalf of the handler such as error pages.
+	RequestHeaders headers.Headers
+	framing        bodyFraming
+	transforms     []Transform
+	// Framer, if set, carries the response in place of HTTP/1.1 framing.
+	Framer Framer
 }
 
 type WriterState int
@@ -22,6 +43,61 @@ const (
 	WriterDone
 )
 
+// bodyFraming records how the body is delimited once the headers are sent.
+type bodyFraming int
+
+const (
+	framingUnknown bodyFraming = iota
+	framingIdentity
+	framingChunked
+	// framingFramed hands the body to the Writer's Framer.
+	framingFramed
+)
+
+// Transform rewrites the parts of a response as they pass through a Writer.
+type Transform interface {
+	// TransformHeaders may modify the header block before it is sent.
+	TransformHeaders(statusCode StatusCode, h headers.Headers)
+	// TransformBody returns the bytes to send in place of p.
+	TransformBody(p []byte) ([]byte, error)
+	// Finish returns any body bytes still held back and may add fields to trailer.
+	Finish(trailer headers.Headers) ([]byte, error)
+}
+
+// Framer carries a response over a protocol that frames the head and body
+// itself, such as HTTP/2. The Writer still runs its transforms but leaves
+// status lines, chunk sizes and trailer sections to the Framer.
+type Framer interface {
+	// WriteHead sends the status code and header fields.
+	WriteHead(statusCode StatusCode, h headers.Headers) error
+	// WriteData sends part of the body.
+	WriteData(p []byte) (int, error)
+	// WriteEnd ends the response, sending any trailer fields.
+	WriteEnd(trailer headers.Headers) error
+}
+
+// CloseNotifier is implemented by writers that learn when the client has
+// gone away without waiting for a write to fail, such as HTTP/2 streams,
+// which the client can reset, and HTTP/1.x connections once the request
+// body has been read.
+type CloseNotifier interface {
+	// CloseNotify returns a channel that is closed once the response can no
+	// longer reach the client.
+	CloseNotify() <-chan struct{}
+}
+
+// Hijacker is implemented by connections that a handler can take over.
+type Hijacker interface {
+	// Hijack returns the connection and any bytes already read from it but
+	// not yet consumed. The caller becomes responsible for closing it.
+	Hijack() (net.Conn, []byte, error)
+}
+
+var (
+	ErrNotHijackable = errors.New("response: connection cannot be hijacked")
+	ErrHijacked      = errors.New("response: connection has been hijacked")
+)
+
 type StatusCode int
 
 const (
@@ -30,85 +106,291 @@ const (
 	StatusCodeServerError StatusCode = 500
 )
 
+var statusText = map[StatusCode]string{
+	StatusCodeOk:          "OK",
+	StatusCodeBadRequest:  "Bad Request",
+	StatusCodeServerError: "Internal Server Error",
+}
+
+// Use adds a transform to the writer. Transforms added later run first, so
+// the outermost middleware sees the output of the ones it wraps.
+func (w *Writer) Use(t Transform) {
+	w.transforms = append([]Transform{t}, w.transforms...)
+}
+
+// Hijack lets the handler take over the underlying connection, for example
+// to switch protocols. The Writer must not be used afterwards.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.State == WriterDone {
+		return nil, nil, ErrHijacked
+	}
+	var (
+		conn     net.Conn
+		buffered []byte
+		err      error
+	)
+	switch c := w.Writer.(type) {
+	case Hijacker:
+		conn, buffered, err = c.Hijack()
+	case net.Conn:
+		conn = c
+	default:
+		err = ErrNotHijackable
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	w.State = WriterDone
+	return conn, buffered, nil
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	// map status code to string
-	statusText := map[StatusCode]string{
-		StatusCodeOk:          "OK",
-		StatusCodeBadRequest:  "Bad Request",
-		StatusCodeServerError: "Internal Server Error",
+	if w.Framer != nil {
+		w.StatusCode = statusCode
+		w.State = WriterStatusLine
+		return nil
+	}
+	text, ok := statusText[statusCode]
+	if !ok {
+		text = http.StatusText(int(statusCode))
+	}
+	version := w.Version
+	if version == "" {
+		version = "1.1"
 	}
-	_, err := w.Write([]byte("HTTP/1.1 " + strconv.Itoa(int(statusCode)) + " " + statusText[statusCode] + "\r\n"))
+	_, err := w.Write([]byte("HTTP/" + version + " " + strconv.Itoa(int(statusCode)) + " " + text + "\r\n"))
 	if err != nil {
 		return err
 	}
+	w.StatusCode = statusCode
+	w.State = WriterStatusLine
 	return nil
 }
 
 // WriteHeaders writes the provided headers to the given writer.
 func (w *Writer) WriteHeaders(h headers.Headers) error {
+	if len(w.transforms) > 0 {
+		h = maps.Clone(h)
+		for _, t := range w.transforms {
+			t.TransformHeaders(w.StatusCode, h)
+		}
+	}
+
+	if w.Framer != nil {
+		w.framing = framingFramed
+		w.State = WriterHeaders
+		return w.Framer.WriteHead(w.StatusCode, h)
+	}
+
+	w.framing = framingIdentity
+	if strings.Contains(strings.ToLower(h.Get("Transfer-Encoding")), "chunked") {
+		w.framing = framingChunked
+	}
+	h = w.connectionHeaders(h)
 
 	for key, value := range h {
-		_, err := w.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, value)))
-		if err != nil {
-			return err
+		// Fields such as Set-Cookie hold one value per line.
+		for _, line := range strings.Split(value, headers.LineSeparator) {
+			_, err := w.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, line)))
+			if err != nil {
+				return err
+			}
 		}
 	}
 	_, err := w.Write([]byte("\r\n")) // End of headers
+	w.State = WriterHeaders
 	return err
 }
 
-func (w *Writer) WriteTrailer(h headers.Headers) error {
-	for key, value := range h {
-		_, err := w.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, value)))
-		if err != nil {
-			return err
+// connectionHeaders adjusts h for the client's HTTP version and decides
+// whether the connection survives the response.
+func (w *Writer) connectionHeaders(h headers.Headers) headers.Headers {
+	http10 := w.Version == "1.0"
+	if http10 {
+		h = maps.Clone(h)
+	}
+	if http10 && w.framing == framingChunked {
+		h.Del("Transfer-Encoding")
+		h.Del("Trailer")
+		w.framing = framingIdentity
+	}
+
+	bodyAllowed := w.StatusCode >= 200 && w.StatusCode != 204 && w.StatusCode != 304
+	if w.framing == framingIdentity && h.Get("Content-Length") == "" && bodyAllowed {
+		w.KeepAlive = false
+	}
+	if headers.HasToken(h.Get("Connection"), "close") {
+		w.KeepAlive = false
+	}
+
+	// An HTTP/1.0 client closes the connection unless told otherwise.
+	if http10 {
+		if w.KeepAlive {
+			h.Set("Connection", "keep-alive")
+		} else {
+			h.Set("Connection", "close")
 		}
 	}
-	_, err := w.Write([]byte("\r\n")) // End of headers
-	return err
+	return h
+}
+
+// WriteTrailer ends a chunked body with the last chunk followed by the trailer fields.
+func (w *Writer) WriteTrailer(h headers.Headers) error {
+	return w.finish(h)
 }
 
+// WriteBody writes p as part of the body, framing it as a chunk if the
+// headers declared a chunked transfer coding.
 func (w *Writer) WriteBody(p []byte) (int, error) {
-	n, err := w.Write(p)
+	p, err := w.transformBody(p)
 	if err != nil {
-		return n, err
+		return 0, err
 	}
-	return n, nil
+	switch w.framing {
+	case framingChunked:
+		return w.writeChunk(p)
+	case framingFramed:
+		return w.writeFramed(p)
+	}
+	w.State = WriterBody
+	return w.Write(p)
 }
 
+// WriteChunkerBody writes p as a single chunk of a chunked body.
 func (w *Writer) WriteChunkerBody(p []byte) (int, error) {
-	n, err := w.Write([]byte(fmt.Sprintf("%x\r\n", len(p))))
+	p, err := w.transformBody(p)
 	if err != nil {
-		return n, err
+		return 0, err
 	}
-	n, err = w.Write(p)
-	if err != nil {
-		return n, err
+	switch w.framing {
+	case framingIdentity:
+		w.State = WriterBody
+		return w.Write(p)
+	case framingFramed:
+		return w.writeFramed(p)
 	}
-	n, err = w.Write([]byte("\r\n"))
-	if err != nil {
-		return n, err
+	return w.writeChunk(p)
+}
+
+// WriteChunkedDone ends the body. A chunked body is closed with the last
+// chunk and any trailer fields contributed by transforms.
+func (w *Writer) WriteChunkedDone() (int, error) {
+	return 0, w.finish(nil)
+}
+
+// Close finishes the response if the handler has not already done so. It is
+// safe to call more than once.
+func (w *Writer) Close() error {
+	if w.State == WriterDone || w.State < WriterHeaders {
+		return nil
+	}
+	return w.finish(nil)
+}
+
+func (w *Writer) transformBody(p []byte) ([]byte, error) {
+	if w.State == WriterDone {
+		return nil, errors.New("response body already finished")
 	}
-	if n == 0 {
-		w.WriteChunkedDone()
-		return n, nil
+	for _, t := range w.transforms {
+		var err error
+		if p, err = t.TransformBody(p); err != nil {
+			return nil, err
+		}
 	}
-	return n, nil
+	return p, nil
 }
 
-func (w *Writer) WriteChunkedDone() (int, error) {
-	n, err := w.Write([]byte("0\r\n\r\n"))
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	w.State = WriterBody
+	if len(p) == 0 {
+		// A zero-length chunk would terminate the body.
+		return 0, nil
+	}
+	if _, err := w.Write([]byte(fmt.Sprintf("%x\r\n", len(p)))); err != nil {
+		return 0, err
+	}
+	n, err := w.Write(p)
 	if err != nil {
 		return n, err
 	}
+	if _, err := w.Write([]byte("\r\n")); err != nil {
+		return n, err
+	}
 	return n, nil
 }
 
+func (w *Writer) writeFramed(p []byte) (int, error) {
+	w.State = WriterBody
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return w.Framer.WriteData(p)
+}
+
+// finish flushes the transforms and terminates the body.
+func (w *Writer) finish(trailer headers.Headers) error {
+	if w.State == WriterDone {
+		return errors.New("response body already finished")
+	}
+
+	if trailer == nil {
+		trailer = headers.NewHeaders()
+	} else {
+		trailer = maps.Clone(trailer)
+	}
+
+	// Each transform's remaining output still has to pass through the
+	// transforms that run after it.
+	for i, t := range w.transforms {
+		rest, err := t.Finish(trailer)
+		if err != nil {
+			return err
+		}
+		for _, next := range w.transforms[i+1:] {
+			if rest, err = next.TransformBody(rest); err != nil {
+				return err
+			}
+		}
+		if len(rest) == 0 {
+			continue
+		}
+		switch w.framing {
+		case framingIdentity:
+			_, err = w.Write(rest)
+		case framingFramed:
+			_, err = w.writeFramed(rest)
+		default:
+			_, err = w.writeChunk(rest)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	w.State = WriterDone
+	switch w.framing {
+	case framingIdentity:
+		return nil
+	case framingFramed:
+		return w.Framer.WriteEnd(trailer)
+	}
+
+	if _, err := w.Write([]byte("0\r\n")); err != nil {
+		return err
+	}
+	for key, value := range trailer {
+		if _, err := w.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, value))); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte("\r\n")) // End of trailer
+	return err
+}
+
 // GetDefaultHeaders generates default HTTP headers, including Content-Length.
 func GetDefaultHeaders(contentLength int) headers.Headers {
 	return map[string]string{
 		"Content-Type":   "text/plain",
 		"Content-Length": fmt.Sprintf("%d", contentLength),
-		"Connection":     "close",
 	}
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"testing"
 
+	"httpfromtcp/internal/headers"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -55,3 +57,131 @@ func TestWriteStatusLine(t *testing.T) {
 		})
 	}
 }
+
+type upperTransform struct{}
+
+func (upperTransform) TransformHeaders(statusCode StatusCode, h headers.Headers) {
+	h.Del("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+}
+
+func (upperTransform) TransformBody(p []byte) ([]byte, error) {
+	return bytes.ToUpper(p), nil
+}
+
+func (upperTransform) Finish(trailer headers.Headers) ([]byte, error) {
+	trailer.Set("X-Upper", "done")
+	return []byte("!"), nil
+}
+
+func TestWriteChunkedBody(t *testing.T) {
+	// Test: Chunked body with trailer
+	var buf bytes.Buffer
+	w := &Writer{Writer: &buf}
+	require.NoError(t, w.WriteStatusLine(StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"Transfer-Encoding": "chunked"}))
+	_, err := w.WriteChunkerBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailer(headers.Headers{"X-Content-Length": "5"}))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nX-Content-Length: 5\r\n\r\n", buf.String())
+
+	// Test: Writing after the body is done
+	_, err = w.WriteChunkerBody([]byte("more"))
+	require.Error(t, err)
+
+	// Test: Transform rewrites a Content-Length response
+	buf.Reset()
+	w = &Writer{Writer: &buf}
+	w.Use(upperTransform{})
+	require.NoError(t, w.WriteStatusLine(StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err = w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+
+	r, err := ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO!", string(r.Body))
+	assert.Equal(t, "", r.Headers.Get("Content-Length"))
+	assert.Equal(t, "done", r.Trailers.Get("X-Upper"))
+}
+
+// recordingFramer records what a Writer hands to its Framer.
+type recordingFramer struct {
+	status  StatusCode
+	headers headers.Headers
+	data    bytes.Buffer
+	trailer headers.Headers
+}
+
+func (f *recordingFramer) WriteHead(statusCode StatusCode, h headers.Headers) error {
+	f.status, f.headers = statusCode, h
+	return nil
+}
+
+func (f *recordingFramer) WriteData(p []byte) (int, error) {
+	return f.data.Write(p)
+}
+
+func (f *recordingFramer) WriteEnd(trailer headers.Headers) error {
+	f.trailer = trailer
+	return nil
+}
+
+func TestWriteHeadersMultipleLines(t *testing.T) {
+	// Test: Each Set-Cookie value is written on its own line
+	var buf bytes.Buffer
+	w := &Writer{Writer: &buf}
+	require.NoError(t, w.WriteStatusLine(StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"Set-Cookie": "a=1\nb=2", "Content-Length": "0"}))
+	assert.Contains(t, buf.String(), "Set-Cookie: a=1\r\nSet-Cookie: b=2\r\n")
+
+	res, err := ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1", "b=2"}, res.Headers.Values("Set-Cookie"))
+}
+
+func TestWriteFramed(t *testing.T) {
+	// Test: Framer receives the transformed parts without HTTP/1.1 framing
+	framer := &recordingFramer{}
+	w := &Writer{Writer: &framer.data, Framer: framer}
+	w.Use(upperTransform{})
+	require.NoError(t, w.WriteStatusLine(StatusCode(404)))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"Transfer-Encoding": "chunked"}))
+	_, err := w.WriteChunkerBody([]byte("not "))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("found"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailer(headers.Headers{"X-Extra": "1"}))
+
+	assert.Equal(t, StatusCode(404), framer.status)
+	assert.Equal(t, "chunked", framer.headers.Get("Transfer-Encoding"))
+	assert.Equal(t, "NOT FOUND!", framer.data.String())
+	assert.Equal(t, headers.Headers{"X-Extra": "1", "X-Upper": "done"}, framer.trailer)
+}
+
+func TestWriteHTTP10(t *testing.T) {
+	// Test: Chunked body is sent as-is and ends with the connection
+	var buf bytes.Buffer
+	w := &Writer{Writer: &buf, Version: "1.0", KeepAlive: true}
+	require.NoError(t, w.WriteStatusLine(StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"Transfer-Encoding": "chunked", "Trailer": "X-Sum"}))
+	_, err := w.WriteChunkerBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailer(headers.Headers{"X-Sum": "1"}))
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nConnection: close\r\n\r\nhello", buf.String())
+	assert.False(t, w.KeepAlive)
+
+	// Test: Keep-alive survives a Content-Length response
+	buf.Reset()
+	w = &Writer{Writer: &buf, Version: "1.0", KeepAlive: true}
+	require.NoError(t, w.WriteStatusLine(StatusCodeOk))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"Content-Length": "2"}))
+	_, err = w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	assert.True(t, w.KeepAlive)
+	r, err := ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "keep-alive", r.Headers.Get("Connection"))
+}
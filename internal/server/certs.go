@@ -0,0 +1,215 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// defaultCertName is the base name of the certificate a CertStore loaded
+// from a directory serves to clients that send no matching server name.
+const defaultCertName = "default"
+
+// CertStore holds the server's certificates and picks one for each TLS
+// handshake by the server name the client asks for (SNI).
+type CertStore struct {
+	// load reads the certificates, the fallback first.
+	load func() ([]tls.Certificate, error)
+	// files lists the files load reads, to notice when they change.
+	files func() ([]string, error)
+
+	// reloadMu serializes reloads and guards stamp, which identifies the
+	// state of the files at the last reload.
+	reloadMu sync.Mutex
+	stamp    string
+
+	mu        sync.RWMutex
+	exact     map[string]*tls.Certificate
+	wildcards map[string]*tls.Certificate
+	fallback  *tls.Certificate
+}
+
+// LoadCertFiles returns a store holding the single key pair in certFile
+// and keyFile.
+func LoadCertFiles(certFile, keyFile string) (*CertStore, error) {
+	load := func() ([]tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return []tls.Certificate{cert}, nil
+	}
+	files := func() ([]string, error) {
+		return []string{certFile, keyFile}, nil
+	}
+	return newCertStore(load, files)
+}
+
+// LoadCertDir returns a store holding every key pair in dir, each made of a
+// NAME.crt certificate chain and a NAME.key private key in PEM. Each
+// certificate is served for the DNS names it is valid for, including
+// wildcard names. Clients that ask for no name, or for one no certificate
+// covers, get default.crt if there is one and otherwise the first pair in
+// name order.
+func LoadCertDir(dir string) (*CertStore, error) {
+	load := func() ([]tls.Certificate, error) {
+		return loadCertDir(dir)
+	}
+	files := func() ([]string, error) {
+		certFiles, err := filepath.Glob(filepath.Join(dir, "*.crt"))
+		if err != nil {
+			return nil, err
+		}
+		keyFiles, err := filepath.Glob(filepath.Join(dir, "*.key"))
+		return append(certFiles, keyFiles...), err
+	}
+	return newCertStore(load, files)
+}
+
+func newCertStore(load func() ([]tls.Certificate, error), files func() ([]string, error)) (*CertStore, error) {
+	cs := &CertStore{load: load, files: files}
+	if err := cs.Reload(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// Reload reads the certificates again. If they cannot be loaded, the store
+// keeps serving the ones it has and Reload returns the error.
+func (cs *CertStore) Reload() error {
+	cs.reloadMu.Lock()
+	defer cs.reloadMu.Unlock()
+	cs.stamp = cs.currentStamp()
+	certs, err := cs.load()
+	if err != nil {
+		return err
+	}
+	return cs.set(certs)
+}
+
+// Changed reports whether the certificate files have been changed, added or
+// removed since the last Reload.
+func (cs *CertStore) Changed() bool {
+	cs.reloadMu.Lock()
+	defer cs.reloadMu.Unlock()
+	return cs.currentStamp() != cs.stamp
+}
+
+// currentStamp describes the name, size and modification time of each file,
+// so that any change to them gives a different stamp.
+func (cs *CertStore) currentStamp() string {
+	files, err := cs.files()
+	if err != nil {
+		return err.Error()
+	}
+	var b strings.Builder
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", file, info.Size(), info.ModTime().UnixNano())
+		} else {
+			fmt.Fprintf(&b, "%s missing\n", file)
+		}
+	}
+	return b.String()
+}
+
+func loadCertDir(dir string) ([]tls.Certificate, error) {
+	certFiles, err := filepath.Glob(filepath.Join(dir, "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	var certs []tls.Certificate
+	for _, certFile := range certFiles {
+		base := strings.TrimSuffix(certFile, ".crt")
+		cert, err := tls.LoadX509KeyPair(certFile, base+".key")
+		if err != nil {
+			return nil, fmt.Errorf("loading %s: %w", filepath.Base(certFile), err)
+		}
+		if filepath.Base(base) == defaultCertName {
+			certs = append([]tls.Certificate{cert}, certs...)
+		} else {
+			certs = append(certs, cert)
+		}
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", dir)
+	}
+	return certs, nil
+}
+
+// set replaces the certificates served with certs, indexing them by the
+// names in their leaf certificates.
+func (cs *CertStore) set(certs []tls.Certificate) error {
+	if len(certs) == 0 {
+		return errors.New("no certificates")
+	}
+	exact := make(map[string]*tls.Certificate)
+	wildcards := make(map[string]*tls.Certificate)
+	for i := range certs {
+		cert := &certs[i]
+		if cert.Leaf == nil {
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				return err
+			}
+			cert.Leaf = leaf
+		}
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			table := exact
+			if strings.HasPrefix(name, "*.") {
+				table, name = wildcards, name[2:]
+			}
+			// With several certificates for a name, the one valid longest wins.
+			if existing, ok := table[name]; !ok || cert.Leaf.NotAfter.After(existing.Leaf.NotAfter) {
+				table[name] = cert
+			}
+		}
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.exact, cs.wildcards, cs.fallback = exact, wildcards, &certs[0]
+	return nil
+}
+
+// GetCertificate picks the certificate for a handshake. It is meant for
+// tls.Config.GetCertificate.
+func (cs *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cert, ok := cs.exact[name]; ok {
+		return cert, nil
+	}
+	// A wildcard covers exactly one label (RFC 6125 section 6.4.3).
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		if cert, ok := cs.wildcards[parent]; ok {
+			return cert, nil
+		}
+	}
+	return cs.fallback, nil
+}
+
+// loadCAPool reads the PEM certificates in caFile into a pool.
+func loadCAPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,395 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertFiles writes cert as NAME.crt and NAME.key in dir.
+func writeCertFiles(t *testing.T, dir, name string, cert tls.Certificate) {
+	t.Helper()
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0o600))
+}
+
+// servedNames returns the DNS names of the certificate served for serverName.
+func servedNames(t *testing.T, certs *CertStore, serverName string) []string {
+	t.Helper()
+	cert, err := certs.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	require.NoError(t, err)
+	require.NotNil(t, cert)
+	return cert.Leaf.DNSNames
+}
+
+func TestLoadCertDir(t *testing.T) {
+	dir := t.TempDir()
+	writeCertFiles(t, dir, "a-example", testCertificate(t, "example.com", "www.example.com"))
+	writeCertFiles(t, dir, "b-wildcard", testCertificate(t, "*.example.com"))
+	writeCertFiles(t, dir, "c-other", testCertificate(t, "other.test"))
+	writeCertFiles(t, dir, "default", testCertificate(t, "fallback.test"))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a certificate"), 0o644))
+
+	certs, err := LoadCertDir(dir)
+	require.NoError(t, err)
+
+	// Test: Exact names
+	assert.Equal(t, []string{"example.com", "www.example.com"}, servedNames(t, certs, "example.com"))
+	assert.Equal(t, []string{"example.com", "www.example.com"}, servedNames(t, certs, "www.example.com"))
+	assert.Equal(t, []string{"other.test"}, servedNames(t, certs, "other.test"))
+
+	// Test: Names are matched case-insensitively and without a trailing dot
+	assert.Equal(t, []string{"other.test"}, servedNames(t, certs, "Other.TEST."))
+
+	// Test: A wildcard covers one label
+	assert.Equal(t, []string{"*.example.com"}, servedNames(t, certs, "api.example.com"))
+	assert.Equal(t, []string{"fallback.test"}, servedNames(t, certs, "a.b.example.com"))
+
+	// Test: Unknown names and clients without SNI get the default
+	assert.Equal(t, []string{"fallback.test"}, servedNames(t, certs, "unknown.test"))
+	assert.Equal(t, []string{"fallback.test"}, servedNames(t, certs, ""))
+}
+
+func TestLoadCertDirWithoutDefault(t *testing.T) {
+	dir := t.TempDir()
+	writeCertFiles(t, dir, "b", testCertificate(t, "b.test"))
+	writeCertFiles(t, dir, "a", testCertificate(t, "a.test"))
+
+	// Test: The first pair by name is the fallback
+	certs, err := LoadCertDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.test"}, servedNames(t, certs, "unknown.test"))
+	assert.Equal(t, []string{"b.test"}, servedNames(t, certs, "b.test"))
+}
+
+func TestLoadCertDirPrefersLongestValid(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeCertFiles(t, dir, "a", testCertificateUntil(t, now.Add(time.Hour), "example.com", "old.example.com"))
+	writeCertFiles(t, dir, "b", testCertificateUntil(t, now.Add(48*time.Hour), "example.com"))
+	writeCertFiles(t, dir, "c", testCertificateUntil(t, now.Add(24*time.Hour), "example.com"))
+
+	// Test: Of several certificates for a name, the one expiring last is served
+	certs, err := LoadCertDir(dir)
+	require.NoError(t, err)
+	cert, err := certs.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	require.NoError(t, err)
+	assert.WithinDuration(t, now.Add(48*time.Hour), cert.Leaf.NotAfter, time.Second)
+	assert.Equal(t, []string{"example.com", "old.example.com"}, servedNames(t, certs, "old.example.com"))
+}
+
+func TestLoadCertDirErrors(t *testing.T) {
+	// Test: An empty directory
+	_, err := LoadCertDir(t.TempDir())
+	assert.Error(t, err)
+
+	// Test: A certificate without its key
+	dir := t.TempDir()
+	writeCertFiles(t, dir, "site", testCertificate(t, "site.test"))
+	require.NoError(t, os.Remove(filepath.Join(dir, "site.key")))
+	_, err = LoadCertDir(dir)
+	assert.ErrorContains(t, err, "site.crt")
+
+	// Test: A key that does not match the certificate
+	dir = t.TempDir()
+	writeCertFiles(t, dir, "site", testCertificate(t, "site.test"))
+	writeCertFiles(t, dir, "other", testCertificate(t, "other.test"))
+	require.NoError(t, os.Rename(filepath.Join(dir, "other.key"), filepath.Join(dir, "site.key")))
+	_, err = LoadCertDir(dir)
+	assert.Error(t, err)
+}
+
+// handshakeName returns the first DNS name of the certificate the server at
+// addr presents, or "" if the handshake fails.
+func handshakeName(addr string) string {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "localhost", InsecureSkipVerify: true})
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].DNSNames[0]
+}
+
+// serverAddr returns the loopback address a server listens on.
+func serverAddr(s *Server) string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+func TestCertStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	writeCertFiles(t, dir, "default", testCertificate(t, "one.test"))
+	certs, err := LoadCertDir(dir)
+	require.NoError(t, err)
+	assert.False(t, certs.Changed())
+
+	// Test: A replaced certificate is noticed and served after Reload
+	writeCertFiles(t, dir, "default", testCertificate(t, "two.test"))
+	assert.True(t, certs.Changed())
+	require.NoError(t, certs.Reload())
+	assert.False(t, certs.Changed())
+	assert.Equal(t, []string{"two.test"}, servedNames(t, certs, ""))
+
+	// Test: Added files count as changes
+	writeCertFiles(t, dir, "extra", testCertificate(t, "extra.test"))
+	assert.True(t, certs.Changed())
+	require.NoError(t, certs.Reload())
+	assert.Equal(t, []string{"extra.test"}, servedNames(t, certs, "extra.test"))
+
+	// Test: A broken certificate is reported and the previous ones stay in use
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "default.crt"), []byte("garbage"), 0o644))
+	assert.Error(t, certs.Reload())
+	assert.False(t, certs.Changed())
+	assert.Equal(t, []string{"two.test"}, servedNames(t, certs, ""))
+	assert.Equal(t, []string{"extra.test"}, servedNames(t, certs, "extra.test"))
+
+	// Test: Removing the broken pair recovers
+	require.NoError(t, os.Remove(filepath.Join(dir, "default.crt")))
+	require.NoError(t, os.Remove(filepath.Join(dir, "default.key")))
+	assert.True(t, certs.Changed())
+	require.NoError(t, certs.Reload())
+	assert.Equal(t, []string{"extra.test"}, servedNames(t, certs, ""))
+}
+
+func TestServeTLSErrors(t *testing.T) {
+	// Test: Certificates that cannot be loaded are returned as errors
+	_, err := ServeTLS(0, namedHandler("ok"), "missing.crt", "missing.key")
+	assert.Error(t, err)
+	_, err = ServeTLSDir(0, namedHandler("ok"), t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestServeTLSCertReload(t *testing.T) {
+	dir := t.TempDir()
+	writeCertFiles(t, dir, "default", testCertificate(t, "one.test"))
+	s, err := ServeTLSDir(0, namedHandler("ok"), dir, WithCertReload(10*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+	addr := serverAddr(s)
+	assert.Equal(t, "one.test", handshakeName(addr))
+
+	// Test: Changed files are picked up without a restart
+	writeCertFiles(t, dir, "default", testCertificate(t, "two.test"))
+	assert.Eventually(t, func() bool { return handshakeName(addr) == "two.test" }, 2*time.Second, 10*time.Millisecond)
+
+	// Test: A broken certificate leaves the previous one in service
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "default.crt"), []byte("garbage"), 0o644))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "two.test", handshakeName(addr))
+
+	// Test: Fixing the files recovers
+	writeCertFiles(t, dir, "default", testCertificate(t, "three.test"))
+	assert.Eventually(t, func() bool { return handshakeName(addr) == "three.test" }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestServeTLSCertReloadOnSIGHUP(t *testing.T) {
+	dir := t.TempDir()
+	writeCertFiles(t, dir, "default", testCertificate(t, "one.test"))
+	s, err := ServeTLSDir(0, namedHandler("ok"), dir, WithCertReload(0))
+	require.NoError(t, err)
+	defer s.Close()
+	addr := serverAddr(s)
+
+	// Test: Without polling, changes wait for SIGHUP
+	writeCertFiles(t, dir, "default", testCertificate(t, "two.test"))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "one.test", handshakeName(addr))
+
+	process, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	if err := process.Signal(syscall.SIGHUP); err != nil {
+		t.Skipf("cannot send SIGHUP: %v", err)
+	}
+	assert.Eventually(t, func() bool { return handshakeName(addr) == "two.test" }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestServeTLSBySNI(t *testing.T) {
+	dir := t.TempDir()
+	writeCertFiles(t, dir, "default", testCertificate(t, "localhost"))
+	writeCertFiles(t, dir, "example", testCertificate(t, "example.com"))
+	certs, err := LoadCertDir(dir)
+	require.NoError(t, err)
+	addr := serveTestTLS(t, &Server{handler: namedHandler("ok")}, &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		NextProtos:     nextProtos,
+	})
+
+	// Test: The handshake presents the certificate for the requested name
+	for serverName, want := range map[string]string{"example.com": "example.com", "": "localhost"} {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		require.NoError(t, err)
+		assert.Equal(t, want, conn.ConnectionState().PeerCertificates[0].DNSNames[0], serverName)
+		conn.Close()
+	}
+}
+
+// testClientCA writes a CA certificate to caFile and returns a function that
+// issues client certificates signed by it.
+func testClientCA(t *testing.T, caFile string) func(commonName string) tls.Certificate {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0o644))
+
+	return func(commonName string) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber:   big.NewInt(time.Now().UnixNano()),
+			Subject:        pkix.Name{CommonName: commonName, Organization: []string{"Example"}},
+			EmailAddresses: []string{commonName + "@example.com"},
+			NotBefore:      time.Now().Add(-time.Hour),
+			NotAfter:       time.Now().Add(time.Hour),
+			KeyUsage:       x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+}
+
+// peerHandler answers with what it learns about the connection from
+// Request.TLS.
+func peerHandler(w *response.Writer, req *request.Request) {
+	h := headers.Headers{"Content-Length": "0", "X-TLS": "no"}
+	if req.TLS != nil {
+		h["X-TLS"] = req.TLS.Protocol
+		if peer := req.TLS.Peer; peer != nil {
+			h["X-Peer-Subject"] = peer.Subject
+			h["X-Peer-Email"] = peer.EmailAddresses[0]
+			h["X-Peer-Fingerprint"] = peer.Fingerprint
+		}
+	}
+	w.WriteStatusLine(response.StatusCodeOk)
+	w.WriteHeaders(h)
+}
+
+// clientCertClient returns an HTTP client that presents cert, if it is not
+// nil, even when the server does not name its issuer as acceptable, and
+// offers the given ALPN protocols.
+func clientCertClient(t *testing.T, cert *tls.Certificate, protos ...string) *http.Client {
+	t.Helper()
+	transport := &http.Transport{
+		ForceAttemptHTTP2: true,
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialer := &tls.Dialer{Config: &tls.Config{
+				GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					if cert == nil {
+						return &tls.Certificate{}, nil
+					}
+					return cert, nil
+				},
+				InsecureSkipVerify: true,
+				NextProtos:         protos,
+			}}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport}
+}
+
+func TestClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	writeCertFiles(t, dir, "server", testCertificate(t, "localhost"))
+	caFile := filepath.Join(dir, "ca.pem")
+	issue := testClientCA(t, caFile)
+	alice := issue("alice")
+	aliceSum := sha256.Sum256(alice.Certificate[0])
+
+	optional, err := ServeTLS(0, peerHandler, filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), WithClientAuth(caFile, false))
+	require.NoError(t, err)
+	defer optional.Close()
+	required, err := ServeTLS(0, peerHandler, filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), WithClientAuth(caFile, true))
+	require.NoError(t, err)
+	defer required.Close()
+
+	// Test: Handlers see the verified identity over HTTP/1.1 and HTTP/2
+	for _, proto := range []string{"http/1.1", "h2"} {
+		for _, s := range []*Server{optional, required} {
+			res, err := clientCertClient(t, &alice, proto).Get("https://" + serverAddr(s) + "/")
+			require.NoError(t, err, proto)
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			assert.Equal(t, proto, res.Header.Get("X-TLS"))
+			assert.Equal(t, "CN=alice,O=Example", res.Header.Get("X-Peer-Subject"))
+			assert.Equal(t, "alice@example.com", res.Header.Get("X-Peer-Email"))
+			assert.Equal(t, hex.EncodeToString(aliceSum[:]), res.Header.Get("X-Peer-Fingerprint"))
+		}
+	}
+
+	// Test: Without a certificate, optional auth still serves the request
+	res, err := clientCertClient(t, nil, "http/1.1").Get("https://" + serverAddr(optional) + "/")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "http/1.1", res.Header.Get("X-TLS"))
+	assert.Empty(t, res.Header.Get("X-Peer-Subject"))
+
+	// Test: Required auth refuses clients without a trusted certificate
+	_, err = clientCertClient(t, nil, "http/1.1").Get("https://" + serverAddr(required) + "/")
+	assert.Error(t, err)
+	mallory := testCertificate(t, "mallory")
+	_, err = clientCertClient(t, &mallory, "h2").Get("https://" + serverAddr(required) + "/")
+	assert.Error(t, err)
+
+	// Test: An untrusted certificate is refused even when auth is optional
+	_, err = clientCertClient(t, &mallory, "http/1.1").Get("https://" + serverAddr(optional) + "/")
+	assert.Error(t, err)
+
+	// Test: A missing CA bundle is an error
+	_, err = ServeTLS(0, peerHandler, filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), WithClientAuth(filepath.Join(dir, "missing.pem"), true))
+	assert.Error(t, err)
+}
+
+func TestPlainRequestHasNoTLS(t *testing.T) {
+	s, err := Serve(0, peerHandler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Requests over plain TCP have no TLS info
+	res, err := http.Get("http://" + serverAddr(s) + "/")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "no", res.Header.Get("X-TLS"))
+}
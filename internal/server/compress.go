@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"log"
+	"strings"
+)
+
+// compressibleTypes lists the media types worth compressing. Anything else,
+// such as video/mp4 or image/png, is usually compressed already.
+var compressibleTypes = []string{
+	"text/html",
+	"text/plain",
+	"text/css",
+	"text/csv",
+	"text/xml",
+	"text/javascript",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/problem+json",
+	"image/svg+xml",
+}
+
+// Compress wraps next so that eligible responses are compressed with gzip or
+// deflate, as negotiated from the request's Accept-Encoding header.
+func Compress(next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method == "HEAD" {
+			next(w, req)
+			return
+		}
+		w.Use(&compressTransform{encoding: negotiateEncoding(req.Headers["accept-encoding"])})
+		next(w, req)
+		if err := w.Close(); err != nil {
+			log.Printf("Error finishing response: %v", err)
+		}
+	}
+}
+
+// compressTransform compresses the body with the negotiated content coding.
+// An empty encoding only marks eligible responses with Vary.
+type compressTransform struct {
+	encoding string
+	buf      bytes.Buffer
+	writer   io.WriteCloser
+}
+
+func (c *compressTransform) TransformHeaders(statusCode response.StatusCode, h headers.Headers) {
+	if (statusCode >= 100 && statusCode < 200) || statusCode == 204 || statusCode == 304 || statusCode == 206 {
+		return
+	}
+	if h.Get("Content-Encoding") != "" || !isCompressible(h.Get("Content-Type")) {
+		return
+	}
+
+	vary := h.Get("Vary")
+	if vary == "" {
+		h.Set("Vary", "Accept-Encoding")
+	} else if !strings.Contains(strings.ToLower(vary), "accept-encoding") {
+		h.Set("Vary", vary+", Accept-Encoding")
+	}
+
+	switch c.encoding {
+	case "gzip":
+		c.writer = gzip.NewWriter(&c.buf)
+	case "deflate":
+		c.writer = zlib.NewWriter(&c.buf)
+	default:
+		return
+	}
+
+	h.Set("Content-Encoding", c.encoding)
+	h.Del("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	// The compressed bytes differ from the ones a strong validator describes.
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+func (c *compressTransform) TransformBody(p []byte) ([]byte, error) {
+	if c.writer == nil {
+		return p, nil
+	}
+	if _, err := c.writer.Write(p); err != nil {
+		return nil, err
+	}
+	return c.take(), nil
+}
+
+func (c *compressTransform) Finish(trailer headers.Headers) ([]byte, error) {
+	if c.writer == nil {
+		return nil, nil
+	}
+	if err := c.writer.Close(); err != nil {
+		return nil, err
+	}
+	return c.take(), nil
+}
+
+// take returns the compressed output produced so far.
+func (c *compressTransform) take() []byte {
+	if c.buf.Len() == 0 {
+		return nil
+	}
+	out := bytes.Clone(c.buf.Bytes())
+	c.buf.Reset()
+	return out
+}
+
+func isCompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	for _, t := range compressibleTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding value,
+// honouring q-values, or returns "" if the body should be left as is.
+func negotiateEncoding(acceptEncoding string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+	// gzip comes first so it wins ties, and identity is only chosen if the
+	// client ranks it higher than both.
+	if coding := negotiate(acceptEncoding, []string{"gzip", "deflate", "identity"}, matchToken); coding != "identity" {
+		return coding
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate, br", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"*", "gzip"},
+		{"*;q=0.3, gzip;q=0", "deflate"},
+		{"br", ""},
+		{"identity;q=1, gzip;q=0.5", ""},
+		{"GZIP;Q=0.8", "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tt.expected, negotiateEncoding(tt.acceptEncoding))
+		})
+	}
+}
+
+func htmlHandler(w *response.Writer, req *request.Request) {
+	body := []byte("<html><body>" + string(bytes.Repeat([]byte("banger "), 50)) + "</body></html>")
+	headers := response.GetDefaultHeaders(len(body))
+	headers["Content-Type"] = "text/html"
+	w.WriteStatusLine(response.StatusCodeOk)
+	w.WriteHeaders(headers)
+	w.WriteBody(body)
+}
+
+func TestCompress(t *testing.T) {
+	var plain bytes.Buffer
+	htmlHandler(&response.Writer{Writer: &plain}, &request.Request{})
+	expected, err := response.ResponseFromReader(&plain)
+	require.NoError(t, err)
+
+	// Test: gzip over a Content-Length body
+	var buf bytes.Buffer
+	Compress(htmlHandler)(&response.Writer{Writer: &buf}, &request.Request{
+		Headers: map[string]string{"accept-encoding": "gzip, deflate"},
+	})
+	res, err := response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", res.Headers.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", res.Headers.Get("Vary"))
+	assert.Equal(t, "", res.Headers.Get("Content-Length"))
+	gz, err := gzip.NewReader(bytes.NewReader(res.Body))
+	require.NoError(t, err)
+	body, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Body, body)
+	assert.Less(t, len(res.Body), len(body))
+
+	// Test: deflate over a chunked body
+	buf.Reset()
+	Compress(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(map[string]string{"Content-Type": "application/json", "Transfer-Encoding": "chunked"})
+		w.WriteChunkerBody([]byte(`{"hello":`))
+		w.WriteChunkerBody([]byte(`"world"}`))
+		w.WriteChunkedDone()
+	})(&response.Writer{Writer: &buf}, &request.Request{
+		Headers: map[string]string{"accept-encoding": "deflate"},
+	})
+	res, err = response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "deflate", res.Headers.Get("Content-Encoding"))
+	zr, err := zlib.NewReader(bytes.NewReader(res.Body))
+	require.NoError(t, err)
+	body, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, `{"hello":"world"}`, string(body))
+
+	// Test: No Accept-Encoding still marks the response with Vary
+	buf.Reset()
+	Compress(htmlHandler)(&response.Writer{Writer: &buf}, &request.Request{})
+	res, err = response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "", res.Headers.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", res.Headers.Get("Vary"))
+	assert.Equal(t, expected.Body, res.Body)
+
+	// Test: Already-compressed types are skipped
+	buf.Reset()
+	Compress(func(w *response.Writer, req *request.Request) {
+		headers := response.GetDefaultHeaders(4)
+		headers["Content-Type"] = "video/mp4"
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(headers)
+		w.WriteBody([]byte("\x00\x01\x02\x03"))
+	})(&response.Writer{Writer: &buf}, &request.Request{
+		Headers: map[string]string{"accept-encoding": "gzip"},
+	})
+	res, err = response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "", res.Headers.Get("Content-Encoding"))
+	assert.Equal(t, "4", res.Headers.Get("Content-Length"))
+	assert.Equal(t, "\x00\x01\x02\x03", string(res.Body))
+}
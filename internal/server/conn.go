@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// conn wraps an accepted connection so that a handler can hijack it.
+type conn struct {
+	net.Conn
+	req      *request.Request
+	tls      *request.TLSInfo
+	hijacked atomic.Bool
+	// watch is the read started by CloseNotify for the current request.
+	watch *closeWatch
+}
+
+// closeWatch is a one-byte read that only returns early if the client
+// closes the connection or sends the start of another request.
+type closeWatch struct {
+	gone chan struct{}
+	done chan struct{}
+	buf  [1]byte
+	n    int
+}
+
+// CloseNotify watches for the client closing the connection while the
+// handler runs. The connection is only read once the request body has
+// been, so the watch never takes request data; before that the channel is
+// never closed. It must be called from the handler.
+func (c *conn) CloseNotify() <-chan struct{} {
+	if c.watch != nil {
+		return c.watch.gone
+	}
+	w := &closeWatch{gone: make(chan struct{}), done: make(chan struct{})}
+	c.watch = w
+	if c.req != nil && !c.req.BodyRead() {
+		close(w.done)
+		return w.gone
+	}
+	go func() {
+		defer close(w.done)
+		n, err := c.Conn.Read(w.buf[:])
+		w.n = n
+		if n == 0 && !errors.Is(err, os.ErrDeadlineExceeded) {
+			close(w.gone)
+		}
+	}()
+	return w.gone
+}
+
+// stopWatch ends the read started by CloseNotify, keeping a byte it read
+// for the next request.
+func (c *conn) stopWatch() {
+	w := c.watch
+	if w == nil {
+		return
+	}
+	c.watch = nil
+	c.Conn.SetReadDeadline(time.Unix(1, 0))
+	<-w.done
+	c.Conn.SetReadDeadline(time.Time{})
+	if p, ok := c.Conn.(*prefixedConn); ok && w.n > 0 {
+		p.prefix = append([]byte{w.buf[0]}, p.prefix...)
+	}
+}
+
+// Hijack hands the connection and the request reader's unread bytes to the
+// caller. The server will no longer write to or close the connection.
+func (c *conn) Hijack() (net.Conn, []byte, error) {
+	if c.hijacked.Swap(true) {
+		return nil, nil, response.ErrHijacked
+	}
+	c.stopWatch()
+	var buffered []byte
+	if c.req != nil {
+		buffered = c.req.Buffered()
+	}
+	return c.Conn, buffered, nil
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	if c.hijacked.Load() {
+		return 0, response.ErrHijacked
+	}
+	return c.Conn.Write(p)
+}
+
+// prefixedConn replays bytes that were read from a connection before it was
+// handed on, such as those inspected to detect the protocol.
+type prefixedConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixedConn) Read(p []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(p, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// CloseWrite shuts down the writing side of the connection if it can be
+// half-closed, as TCP and TLS connections can, and closes it otherwise.
+func (c *prefixedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHijack(t *testing.T) {
+	hijacked := make(chan []byte, 1)
+	handler := func(w *response.Writer, req *request.Request) {
+		conn, buffered, err := w.Hijack()
+		require.NoError(t, err)
+
+		// Test: A second hijack fails
+		_, _, err = w.Hijack()
+		assert.ErrorIs(t, err, response.ErrHijacked)
+
+		// The connection must stay open after the handler returns.
+		go func() {
+			defer conn.Close()
+			rest := make([]byte, 5-len(buffered))
+			io.ReadFull(conn, rest)
+			hijacked <- append(buffered, rest...)
+			time.Sleep(10 * time.Millisecond)
+			conn.Write([]byte("still open"))
+		}()
+	}
+
+	client, serverSide := net.Pipe()
+	defer client.Close()
+	s := &Server{handler: handler}
+	go s.handle(serverSide)
+	go client.Write([]byte("GET /upgrade HTTP/1.1\r\nHost: localhost\r\n\r\nEXTRA"))
+
+	select {
+	case data := <-hijacked:
+		assert.Equal(t, "EXTRA", string(data))
+	case <-time.After(time.Second):
+		t.Fatal("handler did not receive the hijacked bytes")
+	}
+
+	buf := make([]byte, len("still open"))
+	_, err := io.ReadFull(client, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "still open", string(buf))
+}
+
+func TestCloseNotify(t *testing.T) {
+	started := make(chan struct{}, 1)
+	closed := make(chan bool, 1)
+	handler := func(w *response.Writer, req *request.Request) {
+		gone := w.Writer.(response.CloseNotifier).CloseNotify()
+		started <- struct{}{}
+		switch req.Target.Path {
+		case "/wait":
+			select {
+			case <-gone:
+				closed <- true
+			case <-time.After(time.Second):
+				closed <- false
+			}
+			return
+		case "/first":
+			// Give the watch time to read the start of the next request.
+			time.Sleep(50 * time.Millisecond)
+		}
+		echoRequestHandler(w, req)
+	}
+
+	// Test: The client going away closes the channel
+	client, serverSide := net.Pipe()
+	s := &Server{handler: handler}
+	go s.handle(serverSide)
+	go io.WriteString(client, "GET /wait HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+	client.Close()
+	assert.True(t, <-closed)
+
+	// Test: The next request is left for the server to read
+	client, serverSide = net.Pipe()
+	defer client.Close()
+	go s.handle(serverSide)
+	go io.WriteString(client, "GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+	go io.WriteString(client, "GET /second HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	reader := bufio.NewReader(client)
+	for _, want := range []string{"GET /first HTTP/1.1 ", "GET /second HTTP/1.1 "} {
+		res, err := response.ResponseFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(res.Body))
+	}
+	<-started
+}
+
+func TestHijackNotSupported(t *testing.T) {
+	var buf bytes.Buffer
+	w := &response.Writer{Writer: &buf}
+	_, _, err := w.Hijack()
+	require.ErrorIs(t, err, response.ErrNotHijackable)
+}
+
+func TestKeepAlive(t *testing.T) {
+	// Test: Pipelined HTTP/1.1 requests are answered in order on one connection
+	client, reader := roundTrip(t, echoRequestHandler, "GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"POST /two HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc"+
+		"GET /three HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	for _, want := range []string{"GET /one HTTP/1.1 ", "POST /two HTTP/1.1 abc", "GET /three HTTP/1.1 "} {
+		res, err := response.ResponseFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(res.Body))
+	}
+	_, err := reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	client.Close()
+
+	// Test: HTTP/1.0 gets a matching status line and is closed by default
+	_, reader = roundTrip(t, echoRequestHandler, "GET /old HTTP/1.0\r\n\r\n")
+	res, err := response.ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", res.StatusLine.HTTPVersion)
+	assert.Equal(t, "GET /old HTTP/1.0 ", string(res.Body))
+	assert.Equal(t, "close", res.Headers.Get("Connection"))
+
+	// Test: HTTP/1.0 keep-alive when the client asks for it
+	_, reader = roundTrip(t, echoRequestHandler, "GET /a HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"+
+		"GET /b HTTP/1.0\r\n\r\n")
+	res, err = response.ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "keep-alive", res.Headers.Get("Connection"))
+	res, err = response.ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "GET /b HTTP/1.0 ", string(res.Body))
+
+	// Test: An error response from a handler keeps the connection
+	notFound := func(w *response.Writer, req *request.Request) {
+		writeError(w, 404, "no "+req.Target.Path)
+	}
+	_, reader = roundTrip(t, notFound, "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /b HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	for _, want := range []string{"no /a", "no /b"} {
+		res, err = response.ResponseFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(res.Body))
+		assert.Empty(t, res.Headers.Get("Connection"))
+	}
+
+	// Test: Unsupported major version
+	_, reader = roundTrip(t, echoRequestHandler, "GET / HTTP/3.0\r\n\r\n")
+	res, err = response.ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(505), res.StatusLine.StatusCode)
+	assert.Equal(t, "close", res.Headers.Get("Connection"))
+}
+
+func TestAmbiguousFramingClosesConnection(t *testing.T) {
+	// Test: CL.TE request is refused and nothing after it is served
+	_, reader := roundTrip(t, echoRequestHandler, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"0\r\n\r\nGET /smuggled HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	res, err := response.ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(400), res.StatusLine.StatusCode)
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Unknown transfer coding
+	_, reader = roundTrip(t, echoRequestHandler, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: compress\r\n\r\n")
+	res, err = response.ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(501), res.StatusLine.StatusCode)
+}
+
+func TestRequestDecodingLimit(t *testing.T) {
+	var bomb bytes.Buffer
+	gz := gzip.NewWriter(&bomb)
+	gz.Write(bytes.Repeat([]byte("A"), 1<<20))
+	gz.Close()
+	head := "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: gzip\r\nContent-Length: " + strconv.Itoa(bomb.Len()) + "\r\n"
+	reading := func(w *response.Writer, req *request.Request) {
+		if _, err := io.ReadAll(req.BodyReader()); err != nil {
+			WriteBodyError(w, err)
+			return
+		}
+		echoRequestHandler(w, req)
+	}
+
+	for _, streaming := range []bool{false, true} {
+		serve := func(handler Handler, raw string) *response.Response {
+			t.Helper()
+			client, serverSide := net.Pipe()
+			defer client.Close()
+			s := &Server{handler: handler, decodeLimit: 1024, streamBodies: streaming}
+			go s.handle(serverSide)
+			go io.WriteString(client, raw)
+			res, err := response.ResponseFromReader(bufio.NewReader(client))
+			require.NoError(t, err)
+			return res
+		}
+
+		// Test: A body decoded past the limit is answered with 413
+		res := serve(reading, head+"\r\n"+bomb.String())
+		assert.Equal(t, response.StatusCode(413), res.StatusLine.StatusCode, "streaming=%v", streaming)
+		assert.Equal(t, "close", res.Headers.Get("Connection"))
+
+		// Test: So is one read in full to check its digest
+		res = serve(VerifyDigest(reading), head+"Content-Digest: sha-256=:AAAA:\r\n\r\n"+bomb.String())
+		assert.Equal(t, response.StatusCode(413), res.StatusLine.StatusCode, "streaming=%v", streaming)
+	}
+}
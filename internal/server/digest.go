@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// DigestAlgorithm names a hash algorithm from the HTTP Digest Algorithm Values registry.
+type DigestAlgorithm string
+
+const (
+	DigestSHA256 DigestAlgorithm = "sha-256"
+	DigestSHA512 DigestAlgorithm = "sha-512"
+)
+
+// digestFields are the request fields checked by VerifyDigest.
+var digestFields = []string{"Content-Digest", "Repr-Digest"}
+
+// newHash returns a new hash for the algorithm, or nil if it is unsupported.
+func (a DigestAlgorithm) newHash() hash.Hash {
+	switch a {
+	case DigestSHA256:
+		return sha256.New()
+	case DigestSHA512:
+		return sha512.New()
+	default:
+		return nil
+	}
+}
+
+// DigestTrailers wraps next so that its response body is hashed with alg and
+// sent with Content-Digest and Repr-Digest trailers (RFC 9530). The hash
+// covers the bytes sent, after any content coding applied by the handlers it
+// wraps, such as Compress; content coding is part of the representation, so
+// the two digests agree unless the response is a Content-Range part, which
+// only gets Content-Digest. Responses with a Content-Length are switched to
+// chunked encoding so the trailers can follow the body. Responses to HEAD
+// are left alone, since they have no body to follow.
+func DigestTrailers(alg DigestAlgorithm, next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method == "HEAD" {
+			next(w, req)
+			return
+		}
+		h := alg.newHash()
+		if h == nil {
+			log.Printf("Unsupported digest algorithm %q", alg)
+			next(w, req)
+			return
+		}
+		w.Use(&digestTransform{alg: alg, hash: h})
+		next(w, req)
+		if err := w.Close(); err != nil {
+			log.Printf("Error finishing response: %v", err)
+		}
+	}
+}
+
+// digestTransform hashes the body on its way out and adds the digest trailers.
+type digestTransform struct {
+	alg    DigestAlgorithm
+	hash   hash.Hash
+	active bool
+	// partial is set for a Content-Range response, whose body is not the
+	// whole representation.
+	partial bool
+}
+
+func (d *digestTransform) TransformHeaders(statusCode response.StatusCode, h headers.Headers) {
+	if (statusCode >= 100 && statusCode < 200) || statusCode == 204 || statusCode == 304 {
+		return
+	}
+	d.active = true
+	d.partial = h.Get("Content-Range") != ""
+	h.Del("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+
+	declareTrailers(h, "Content-Digest")
+	if !d.partial {
+		declareTrailers(h, "Repr-Digest")
+	}
+}
+
+// declareTrailers adds fields to the Trailer header of h.
+func declareTrailers(h headers.Headers, fields ...string) {
+	declared := h.Get("Trailer")
+	for _, field := range fields {
+		if declared == "" {
+			declared = field
+		} else if !headers.HasToken(declared, field) {
+			declared += ", " + field
+		}
+	}
+	h.Set("Trailer", declared)
+}
+
+func (d *digestTransform) TransformBody(p []byte) ([]byte, error) {
+	d.hash.Write(p)
+	return p, nil
+}
+
+func (d *digestTransform) Finish(trailer headers.Headers) ([]byte, error) {
+	if !d.active {
+		return nil, nil
+	}
+	digest := formatDigest(d.alg, d.hash.Sum(nil))
+	trailer.Set("Content-Digest", digest)
+	if !d.partial {
+		trailer.Set("Repr-Digest", digest)
+	}
+	return nil, nil
+}
+
+// VerifyDigest wraps next so that requests carrying a Content-Digest or
+// Repr-Digest header are rejected with 400 if the body does not match.
+// Digests using unsupported algorithms are ignored. A streamed body is read
+// in full before next is called, since it can only be trusted once all of it
+// has been checked; requests without a digest keep streaming. The digest is
+// checked against the body as received, before WithRequestDecoding removes
+// any content coding.
+func VerifyDigest(next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		for _, field := range digestFields {
+			value := req.Headers[strings.ToLower(field)]
+			if value == "" {
+				continue
+			}
+			if err := req.ReadBody(); err != nil {
+				WriteBodyError(w, err)
+				return
+			}
+			digests, err := parseDigest(value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid %s header", field))
+				return
+			}
+			for alg, expected := range digests {
+				h := alg.newHash()
+				if h == nil {
+					continue
+				}
+				h.Write(req.EncodedBody())
+				if !bytes.Equal(h.Sum(nil), expected) {
+					writeError(w, http.StatusBadRequest, fmt.Sprintf("%s does not match request body", field))
+					return
+				}
+			}
+		}
+		next(w, req)
+	}
+}
+
+// formatDigest serialises a digest as a structured field dictionary member.
+func formatDigest(alg DigestAlgorithm, sum []byte) string {
+	return fmt.Sprintf("%s=:%s:", alg, base64.StdEncoding.EncodeToString(sum))
+}
+
+// parseDigest parses a Content-Digest or Repr-Digest dictionary.
+func parseDigest(value string) (map[DigestAlgorithm][]byte, error) {
+	digests := make(map[DigestAlgorithm][]byte)
+	for _, member := range strings.Split(value, ",") {
+		alg, encoded, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid digest member: %s", member)
+		}
+		// Drop any parameters after the byte sequence.
+		encoded, _, _ = strings.Cut(encoded, ";")
+		if len(encoded) < 2 || encoded[0] != ':' || encoded[len(encoded)-1] != ':' {
+			return nil, fmt.Errorf("invalid digest value: %s", encoded)
+		}
+		sum, err := base64.StdEncoding.DecodeString(encoded[1 : len(encoded)-1])
+		if err != nil {
+			return nil, err
+		}
+		digests[DigestAlgorithm(strings.ToLower(alg))] = sum
+	}
+	return digests, nil
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func helloHandler(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.StatusCodeOk)
+	w.WriteHeaders(response.GetDefaultHeaders(12))
+	w.WriteBody([]byte("hello world!"))
+}
+
+func TestDigestTrailers(t *testing.T) {
+	// Test: SHA-256 trailers on a Content-Length response
+	var buf bytes.Buffer
+	handler := DigestTrailers(DigestSHA256, helloHandler)
+	handler(&response.Writer{Writer: &buf}, &request.Request{})
+
+	res, err := response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte("hello world!"))
+	expected := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	assert.Equal(t, "hello world!", string(res.Body))
+	assert.Equal(t, "chunked", res.Headers.Get("Transfer-Encoding"))
+	assert.Equal(t, "Content-Digest, Repr-Digest", res.Headers.Get("Trailer"))
+	assert.Equal(t, expected, res.Trailers.Get("Content-Digest"))
+	assert.Equal(t, expected, res.Trailers.Get("Repr-Digest"))
+
+	// Test: SHA-512 trailers on a chunked response
+	buf.Reset()
+	handler = DigestTrailers(DigestSHA512, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(map[string]string{"Transfer-Encoding": "chunked"})
+		w.WriteChunkerBody([]byte("hello "))
+		w.WriteChunkerBody([]byte("world!"))
+		w.WriteChunkedDone()
+	})
+	handler(&response.Writer{Writer: &buf}, &request.Request{})
+
+	res, err = response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	sum512 := sha512.Sum512([]byte("hello world!"))
+	assert.Equal(t, "hello world!", string(res.Body))
+	assert.Equal(t, "sha-512=:"+base64.StdEncoding.EncodeToString(sum512[:])+":", res.Trailers.Get("Content-Digest"))
+
+	// Test: Under Compress the digest covers the compressed bytes sent
+	buf.Reset()
+	handler = DigestTrailers(DigestSHA256, Compress(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(map[string]string{"Content-Type": "text/plain", "Content-Length": "12"})
+		w.WriteBody([]byte("hello world!"))
+	}))
+	handler(&response.Writer{Writer: &buf}, &request.Request{Headers: map[string]string{"accept-encoding": "gzip"}})
+
+	res, err = response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", res.Headers.Get("Content-Encoding"))
+	sum = sha256.Sum256(res.Body)
+	assert.Equal(t, "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":", res.Trailers.Get("Content-Digest"))
+	assert.Equal(t, res.Trailers.Get("Content-Digest"), res.Trailers.Get("Repr-Digest"))
+
+	// Test: A Content-Range part only gets Content-Digest
+	buf.Reset()
+	handler = DigestTrailers(DigestSHA256, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(206)
+		w.WriteHeaders(map[string]string{"Content-Range": "bytes 0-4/12", "Content-Length": "5"})
+		w.WriteBody([]byte("hello"))
+	})
+	handler(&response.Writer{Writer: &buf}, &request.Request{})
+
+	res, err = response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	sum = sha256.Sum256([]byte("hello"))
+	assert.Equal(t, "Content-Digest", res.Headers.Get("Trailer"))
+	assert.Equal(t, "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":", res.Trailers.Get("Content-Digest"))
+	assert.Empty(t, res.Trailers.Get("Repr-Digest"))
+
+	// Test: HEAD responses keep their framing and get no trailer
+	buf.Reset()
+	handler = DigestTrailers(DigestSHA256, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(response.GetDefaultHeaders(12))
+	})
+	handler(&response.Writer{Writer: &buf}, &request.Request{RequestLine: request.RequestLine{Method: "HEAD"}})
+	assert.Contains(t, buf.String(), "Content-Length: 12\r\n")
+	assert.NotContains(t, buf.String(), "Trailer")
+	assert.NotContains(t, buf.String(), "chunked")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+	assert.NotContains(t, buf.String(), "0\r\n\r\n")
+}
+
+func TestVerifyDigest(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello world!"))
+	good := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	handler := VerifyDigest(helloHandler)
+
+	// Test: Matching digest
+	var buf bytes.Buffer
+	handler(&response.Writer{Writer: &buf}, &request.Request{
+		Headers: map[string]string{"content-digest": good},
+		Body:    []byte("hello world!"),
+	})
+	res, err := response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeOk, res.StatusLine.StatusCode)
+
+	// Test: Mismatched digest
+	buf.Reset()
+	handler(&response.Writer{Writer: &buf}, &request.Request{
+		Headers: map[string]string{"repr-digest": good},
+		Body:    []byte("tampered"),
+	})
+	res, err = response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeBadRequest, res.StatusLine.StatusCode)
+
+	// Test: Malformed digest
+	buf.Reset()
+	handler(&response.Writer{Writer: &buf}, &request.Request{
+		Headers: map[string]string{"content-digest": "sha-256=abc"},
+		Body:    []byte("hello world!"),
+	})
+	res, err = response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeBadRequest, res.StatusLine.StatusCode)
+
+	// Test: Unsupported algorithms are ignored
+	buf.Reset()
+	handler(&response.Writer{Writer: &buf}, &request.Request{
+		Headers: map[string]string{"content-digest": "md5=:AAAA:"},
+		Body:    []byte("hello world!"),
+	})
+	res, err = response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeOk, res.StatusLine.StatusCode)
+}
+
+func TestVerifyDigestStreaming(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello world!"))
+	good := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	var received string
+	handler := VerifyDigest(func(w *response.Writer, req *request.Request) {
+		body, _ := io.ReadAll(req.BodyReader())
+		received = string(body)
+		helloHandler(w, req)
+	})
+	serve := func(digest, body string) response.StatusCode {
+		t.Helper()
+		req, err := request.RequestHeadFromReader(strings.NewReader(
+			"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Digest: " + digest +
+				"\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		handler(&response.Writer{Writer: &buf}, req)
+		res, err := response.ResponseFromReader(&buf)
+		require.NoError(t, err)
+		return res.StatusLine.StatusCode
+	}
+
+	// Test: A streamed body is checked and still reaches the handler
+	assert.Equal(t, response.StatusCodeOk, serve(good, "hello world!"))
+	assert.Equal(t, "hello world!", received)
+
+	// Test: A streamed body that does not match is rejected
+	received = ""
+	assert.Equal(t, response.StatusCodeBadRequest, serve(good, "tampered"))
+	assert.Empty(t, received)
+}
+
+func TestVerifyDigestDecoded(t *testing.T) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte("hello world!"))
+	gz.Close()
+	sum := sha256.Sum256(gzipped.Bytes())
+	coded := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	sum = sha256.Sum256([]byte("hello world!"))
+	decoded := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+
+	for _, streaming := range []bool{false, true} {
+		var received string
+		s := &Server{decodeLimit: 1024, streamBodies: streaming, handler: VerifyDigest(func(w *response.Writer, req *request.Request) {
+			body, _ := io.ReadAll(req.BodyReader())
+			received = string(body)
+			helloHandler(w, req)
+		})}
+		serve := func(digest string) response.StatusCode {
+			t.Helper()
+			client, serverSide := net.Pipe()
+			defer client.Close()
+			go s.handle(serverSide)
+			go io.WriteString(client, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: gzip\r\n"+
+				"Content-Digest: "+digest+"\r\nContent-Length: "+strconv.Itoa(gzipped.Len())+"\r\n\r\n"+gzipped.String())
+			res, err := response.ResponseFromReader(bufio.NewReader(client))
+			require.NoError(t, err)
+			return res.StatusLine.StatusCode
+		}
+
+		// Test: The digest of the gzip bytes matches, and the handler gets them decoded
+		assert.Equal(t, response.StatusCodeOk, serve(coded), "streaming=%v", streaming)
+		assert.Equal(t, "hello world!", received)
+
+		// Test: The digest of the decoded bytes does not
+		assert.Equal(t, response.StatusCodeBadRequest, serve(decoded), "streaming=%v", streaming)
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ForwardProxy lets the server act as a forward HTTP proxy. It forwards
+// absolute-form requests and opens TCP tunnels for CONNECT.
+type ForwardProxy struct {
+	// Username and Password, if set, are required as Basic credentials in
+	// the Proxy-Authorization header.
+	Username string
+	Password string
+	// AllowedPorts restricts the destination ports. An empty list allows any port.
+	AllowedPorts []int
+	// DialTimeout bounds how long opening a tunnel may take. Zero means 10 seconds.
+	DialTimeout time.Duration
+}
+
+// Handler returns a handler that serves proxy requests and passes requests in
+// origin-form on to next.
+func (fp *ForwardProxy) Handler(next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		isConnect := req.Target.Form == request.AuthorityForm
+		if !isConnect && req.Target.Form != request.AbsoluteForm {
+			if next == nil {
+				writeError(w, http.StatusBadRequest, "Bad Request")
+				return
+			}
+			next(w, req)
+			return
+		}
+
+		if !fp.authorized(req) {
+			writeProxyAuthRequired(w)
+			return
+		}
+
+		if isConnect {
+			fp.tunnel(w, req)
+			return
+		}
+
+		if req.Target.Scheme != "http" {
+			writeError(w, http.StatusBadRequest, "Only http:// targets can be proxied, use CONNECT for https")
+			return
+		}
+		port := "80"
+		if _, p, err := net.SplitHostPort(req.Target.Host); err == nil && p != "" {
+			port = p
+		}
+		if !fp.portAllowed(port) {
+			writeError(w, http.StatusForbidden, "Destination port not allowed")
+			return
+		}
+		target := "http://" + req.Target.Host + req.Target.RawPath
+		if req.Target.RawQuery != "" {
+			target += "?" + req.Target.RawQuery
+		}
+		forward(w, req, target, nil)
+	}
+}
+
+// tunnel answers a CONNECT request and splices bytes between the client and
+// the destination until either side closes.
+func (fp *ForwardProxy) tunnel(w *response.Writer, req *request.Request) {
+	_, port, _ := net.SplitHostPort(req.Target.Host)
+	if !fp.portAllowed(port) {
+		writeError(w, http.StatusForbidden, "Destination port not allowed")
+		return
+	}
+
+	timeout := fp.DialTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	upstream, err := net.DialTimeout("tcp", req.Target.Host, timeout)
+	if err != nil {
+		log.Printf("Error dialing %s: %v", req.Target.Host, err)
+		writeError(w, http.StatusBadGateway, "Bad Gateway")
+		return
+	}
+	defer upstream.Close()
+
+	client, buffered, err := w.Hijack()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Connection cannot be tunneled")
+		return
+	}
+	defer client.Close()
+
+	// A 2xx response to CONNECT has no body and no framing headers.
+	cw := &response.Writer{Writer: client}
+	if err := cw.WriteStatusLine(response.StatusCodeOk); err != nil {
+		log.Printf("Error writing status line: %v", err)
+		return
+	}
+	if err := cw.WriteHeaders(headers.NewHeaders()); err != nil {
+		log.Printf("Error writing headers: %v", err)
+		return
+	}
+
+	// Anything the client sent early belongs to the tunnel.
+	if len(buffered) > 0 {
+		if _, err := upstream.Write(buffered); err != nil {
+			log.Printf("Error tunneling data: %v", err)
+			return
+		}
+	}
+
+	done := make(chan struct{}, 2)
+	go splice(upstream, client, done)
+	go splice(client, upstream, done)
+	<-done
+	<-done
+}
+
+// splice copies src to dst, then signals the end of the stream to dst.
+func splice(dst, src net.Conn, done chan<- struct{}) {
+	if _, err := io.Copy(dst, src); err != nil {
+		log.Printf("Error tunneling data: %v", err)
+	}
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+	done <- struct{}{}
+}
+
+// authorized checks the Proxy-Authorization header when credentials are configured.
+func (fp *ForwardProxy) authorized(req *request.Request) bool {
+	if fp.Username == "" && fp.Password == "" {
+		return true
+	}
+	scheme, encoded, ok := strings.Cut(req.Headers["proxy-authorization"], " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	user, pass, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false
+	}
+	userOk := subtle.ConstantTimeCompare([]byte(user), []byte(fp.Username)) == 1
+	passOk := subtle.ConstantTimeCompare([]byte(pass), []byte(fp.Password)) == 1
+	return userOk && passOk
+}
+
+func (fp *ForwardProxy) portAllowed(port string) bool {
+	if len(fp.AllowedPorts) == 0 {
+		return true
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(fp.AllowedPorts, p)
+}
+
+// writeProxyAuthRequired asks the client for proxy credentials.
+func writeProxyAuthRequired(w *response.Writer) {
+	hErr := &HandlerError{
+		StatusCode: http.StatusProxyAuthRequired,
+		Message:    "Proxy Authentication Required",
+		Headers:    headers.Headers{"Proxy-Authenticate": `Basic realm="proxy"`},
+	}
+	if err := hErr.Write(w); err != nil {
+		log.Printf("Error writing error response: %v", err)
+	}
+}
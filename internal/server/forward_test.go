@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip sends raw over an in-memory connection to a server running
+// handler and returns the client end with its buffered reader.
+func roundTrip(t *testing.T, handler Handler, raw string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	client, conn := net.Pipe()
+	s := &Server{handler: handler}
+	go s.handle(conn)
+	t.Cleanup(func() { client.Close() })
+	go func() {
+		io.WriteString(client, raw)
+	}()
+	return client, bufio.NewReader(client)
+}
+
+func TestForwardProxyConnect(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(echo.Addr().String())
+	echoPort, _ := strconv.Atoi(port)
+
+	// Test: Tunnel bytes in both directions
+	fp := &ForwardProxy{AllowedPorts: []int{echoPort}}
+	client, reader := roundTrip(t, fp.Handler(nil), fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", echo.Addr(), echo.Addr()))
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+	blank, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", blank)
+	_, err = client.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(reader, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	// Test: Port outside the allow-list
+	fp = &ForwardProxy{AllowedPorts: []int{443}}
+	_, reader = roundTrip(t, fp.Handler(nil), fmt.Sprintf("CONNECT %[1]s HTTP/1.1\r\nHost: %[1]s\r\n\r\n", echo.Addr()))
+	res, err := response.ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(http.StatusForbidden), res.StatusLine.StatusCode)
+}
+
+func TestForwardProxyAuth(t *testing.T) {
+	fp := &ForwardProxy{Username: "user", Password: "secret"}
+
+	// Test: Missing credentials
+	_, reader := roundTrip(t, fp.Handler(nil), "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	res, err := response.ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(http.StatusProxyAuthRequired), res.StatusLine.StatusCode)
+	assert.Equal(t, `Basic realm="proxy"`, res.Headers.Get("Proxy-Authenticate"))
+
+	// Test: Wrong credentials
+	bad := base64.StdEncoding.EncodeToString([]byte("user:wrong"))
+	_, reader = roundTrip(t, fp.Handler(nil), "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\nProxy-Authorization: Basic "+bad+"\r\n\r\n")
+	res, err = response.ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(http.StatusProxyAuthRequired), res.StatusLine.StatusCode)
+
+	// Test: Origin-form requests skip the proxy
+	_, reader = roundTrip(t, fp.Handler(helloHandler), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	res, err = response.ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeOk, res.StatusLine.StatusCode)
+	assert.Equal(t, "hello world!", string(res.Body))
+}
+
+func TestForwardProxyAbsoluteForm(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	}))
+	defer upstream.Close()
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	fp := &ForwardProxy{Username: "user", Password: "secret"}
+	_, reader := roundTrip(t, fp.Handler(nil), "POST "+upstream.URL+"/submit HTTP/1.1\r\n"+
+		"Host: "+strings.TrimPrefix(upstream.URL, "http://")+"\r\n"+
+		"Proxy-Authorization: Basic "+credentials+"\r\n"+
+		"Content-Length: 5\r\n"+
+		"\r\n"+
+		"hello")
+	res, err := response.ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeOk, res.StatusLine.StatusCode)
+	assert.Equal(t, "text/plain", res.Headers.Get("Content-Type"))
+	assert.Equal(t, "POST /submit hello", string(res.Body))
+}
+
+func TestForwardProxyHalfClose(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("banner"))
+		conn.(*net.TCPConn).CloseWrite()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	s, err := Serve(0, (&ForwardProxy{}).Handler(nil))
+	require.NoError(t, err)
+	defer s.Close()
+	client, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(client, "CONNECT %[1]s HTTP/1.1\r\nHost: %[1]s\r\n\r\n", upstream.Addr())
+	reader := bufio.NewReader(client)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+	_, err = reader.ReadString('\n')
+	require.NoError(t, err)
+
+	// Test: The upstream's half-close reaches the client as EOF
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "banner", string(data))
+
+	// Test: The client can still send until it half-closes too
+	_, err = client.Write([]byte("late"))
+	require.NoError(t, err)
+	require.NoError(t, client.(*net.TCPConn).CloseWrite())
+	assert.Equal(t, "late", <-received)
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/http2"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"log"
+	"net"
+	"strings"
+)
+
+// nextProtos are the protocols offered during the TLS handshake, in order
+// of preference.
+var nextProtos = []string{"h2", "http/1.1"}
+
+// detectHTTP2 reports whether the client speaks HTTP/2 on netConn: over TLS
+// when it chose h2 through ALPN, and in cleartext, if h2c is enabled, when
+// the connection starts with the HTTP/2 preface. The returned connection
+// must be used in place of netConn.
+func (s *Server) detectHTTP2(netConn net.Conn) (net.Conn, bool, error) {
+	if tlsConn, ok := netConn.(*tls.Conn); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+		defer cancel()
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return netConn, false, err
+		}
+		return netConn, tlsConn.ConnectionState().NegotiatedProtocol == "h2", nil
+	}
+	if !s.h2c {
+		return netConn, false, nil
+	}
+
+	// Read only while the bytes still match the preface, so that a short
+	// HTTP/1 request never waits for data that will not come.
+	var sniffed []byte
+	buf := make([]byte, len(http2.Preface))
+	for {
+		n, err := netConn.Read(buf)
+		sniffed = append(sniffed, buf[:n]...)
+		matched := min(len(sniffed), len(http2.Preface))
+		if !bytes.Equal(sniffed[:matched], []byte(http2.Preface[:matched])) {
+			return &prefixedConn{Conn: netConn, prefix: sniffed}, false, nil
+		}
+		if matched == len(http2.Preface) {
+			return &prefixedConn{Conn: netConn, prefix: sniffed}, true, nil
+		}
+		if err != nil {
+			return netConn, false, err
+		}
+	}
+}
+
+// handler2 wraps the handler for requests arriving over HTTP/2, whose
+// bodies are always read in full.
+func (s *Server) handler2(w *response.Writer, req *request.Request) {
+	if s.decodeLimit > 0 {
+		if err := req.DecodeContentEncoding(s.decodeLimit); err != nil {
+			WriteBodyError(w, err)
+			return
+		}
+	}
+	s.handler(w, req)
+}
+
+// serveHTTP2 serves every stream of an HTTP/2 connection with the handler.
+// tlsInfo describes the connection if it is TLS.
+func (s *Server) serveHTTP2(netConn net.Conn, tlsInfo *request.TLSInfo) {
+	handler := func(w *response.Writer, req *request.Request) {
+		req.TLS = tlsInfo
+		s.handler2(w, req)
+	}
+	if err := http2.ServeConn(netConn, handler); err != nil {
+		log.Printf("Error serving HTTP/2 connection: %v", err)
+	}
+}
+
+// isH2CUpgrade reports whether req asks to switch to cleartext HTTP/2 with
+// exactly one HTTP2-Settings field, as RFC 7540 section 3.2 requires.
+func isH2CUpgrade(req *request.Request) bool {
+	if req.RequestLine.HTTPVersion != "1.1" {
+		return false
+	}
+	if !headers.HasToken(req.Headers["upgrade"], "h2c") || !headers.HasToken(req.Headers["connection"], "http2-settings") {
+		return false
+	}
+	// Repeated fields are joined with commas, which base64url never contains.
+	settings, ok := req.Headers["http2-settings"]
+	return ok && !strings.Contains(settings, ",")
+}
+
+// upgradeH2C switches the connection to HTTP/2 and answers req as stream 1.
+func (s *Server) upgradeH2C(c *conn, req *request.Request) {
+	settings, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(req.Headers["http2-settings"], "="))
+	if err == nil {
+		err = req.ReadBody()
+	}
+	if err != nil {
+		writeError(&response.Writer{Writer: c}, 400, "invalid h2c upgrade: "+err.Error())
+		return
+	}
+
+	w := &response.Writer{Writer: c}
+	err = w.WriteStatusLine(101)
+	if err == nil {
+		err = w.WriteHeaders(headers.Headers{"Connection": "Upgrade", "Upgrade": "h2c"})
+	}
+	if err != nil {
+		return
+	}
+
+	// The client may already have sent its preface after the request.
+	upgraded := &prefixedConn{Conn: c.Conn, prefix: req.Buffered()}
+	err = http2.ServeUpgradedConn(upgraded, s.handler2, req, settings)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error serving upgraded HTTP/2 connection: %v", err)
+	}
+}
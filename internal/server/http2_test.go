@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/http2"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate returns a self-signed certificate for hosts.
+func testCertificate(t *testing.T, hosts ...string) tls.Certificate {
+	t.Helper()
+	return testCertificateUntil(t, time.Now().Add(time.Hour), hosts...)
+}
+
+// testCertificateUntil is like testCertificate but expires at notAfter.
+func testCertificateUntil(t *testing.T, notAfter time.Time, hosts ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveTestTLS serves s over TLS on a loopback port and returns its address.
+func serveTestTLS(t *testing.T, s *Server, config *tls.Config) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	tlsListener := tls.NewListener(listener, config)
+	go func() {
+		for {
+			conn, err := tlsListener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// tlsClient returns an HTTP client that offers the given ALPN protocols.
+func tlsClient(t *testing.T, protos ...string) *http.Client {
+	t.Helper()
+	transport := &http.Transport{
+		ForceAttemptHTTP2: true,
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialer := &tls.Dialer{Config: &tls.Config{
+				InsecureSkipVerify: true,
+				NextProtos:         protos,
+			}}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport}
+}
+
+func TestServeHTTP2(t *testing.T) {
+	s := &Server{handler: func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(headers.Headers{
+			"Content-Type":      "text/plain",
+			"Transfer-Encoding": "chunked",
+			"Trailer":           "X-Length",
+			"X-Protocol":        req.RequestLine.HTTPVersion,
+		})
+		n, _ := w.WriteBody([]byte(strings.Repeat(string(req.Body), 100000)))
+		w.WriteTrailer(headers.Headers{"X-Length": strconv.Itoa(n)})
+	}}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t, "localhost")},
+		NextProtos:   nextProtos,
+	}
+	url := "https://" + serveTestTLS(t, s, config) + "/"
+	client := tlsClient(t, "h2", "http/1.1")
+
+	// Test: Concurrent requests are multiplexed over one connection
+	var wg sync.WaitGroup
+	for _, letter := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Post(url, "text/plain", strings.NewReader(letter))
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, "HTTP/2.0", res.Proto)
+			assert.Equal(t, "2", res.Header.Get("X-Protocol"))
+			assert.Equal(t, strings.Repeat(letter, 100000), string(body))
+			assert.Equal(t, "100000", res.Trailer.Get("X-Length"))
+		}()
+	}
+	wg.Wait()
+
+	// Test: Clients that only offer HTTP/1.1 still get it
+	res, err := tlsClient(t, "http/1.1").Get(url)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "HTTP/1.1", res.Proto)
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { handshakeTimeout = timeout }(handshakeTimeout)
+	handshakeTimeout = 50 * time.Millisecond
+	config := &tls.Config{Certificates: []tls.Certificate{testCertificate(t, "localhost")}}
+	addr := serveTestTLS(t, &Server{handler: echoRequestHandler}, config)
+
+	// Test: A client that never starts the handshake is disconnected
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+// readH2Response reads frames until stream 1 ends and returns its status
+// and body.
+func readH2Response(t *testing.T, r io.Reader) (string, string) {
+	t.Helper()
+	decoder := http2.NewDecoder(4096)
+	var status string
+	var body strings.Builder
+	for {
+		f, err := http2.ReadFrame(r, 1<<24-1)
+		require.NoError(t, err)
+		if f.StreamID != 1 {
+			continue
+		}
+		switch f.Type {
+		case http2.FrameHeaders:
+			fields, err := decoder.Decode(f.Payload)
+			require.NoError(t, err)
+			if status == "" {
+				status = fields[0].Value
+			}
+		case http2.FrameData:
+			body.Write(f.Payload)
+		}
+		if f.Has(http2.FlagEndStream) {
+			return status, body.String()
+		}
+	}
+}
+
+// clientPreface is the preface followed by an empty SETTINGS frame.
+func clientPreface() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(http2.Preface)
+	http2.WriteFrame(&buf, &http2.Frame{Type: http2.FrameSettings})
+	return buf.Bytes()
+}
+
+func echoRequestHandler(w *response.Writer, req *request.Request) {
+	body := req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " HTTP/" + req.RequestLine.HTTPVersion + " " + string(req.Body)
+	w.WriteStatusLine(response.StatusCodeOk)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+func TestServeH2C(t *testing.T) {
+	// Test: Prior knowledge, detected from the connection preface
+	var raw bytes.Buffer
+	raw.Write(clientPreface())
+	block := http2.Encoder{}.Encode(nil, []http2.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/direct"},
+		{Name: ":authority", Value: "localhost"},
+	})
+	http2.WriteFrame(&raw, &http2.Frame{
+		Type:     http2.FrameHeaders,
+		Flags:    http2.FlagEndHeaders | http2.FlagEndStream,
+		StreamID: 1,
+		Payload:  block,
+	})
+	client, reader := roundTripH2C(t, raw.String())
+	status, body := readH2Response(t, reader)
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "GET /direct HTTP/2 ", body)
+	client.Close()
+
+	// Test: Upgrade from HTTP/1.1 answers the first request on stream 1
+	upgrade := "POST /upgraded HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\n" +
+		"HTTP2-Settings: AAMAAABkAAQAoAAAAAIAAAAA\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello" + string(clientPreface())
+	client, reader = roundTripH2C(t, upgrade)
+	res, err := readHandshakeHead(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", res[0])
+	assert.Contains(t, res, "Upgrade: h2c\r\n")
+	status, body = readH2Response(t, reader)
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "POST /upgraded HTTP/1.1 hello", body)
+	client.Close()
+
+	// Test: Without WithH2C the upgrade is ignored
+	_, reader = roundTrip(t, echoRequestHandler, upgrade)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+}
+
+// roundTripH2C is roundTrip for a server with h2c enabled.
+func roundTripH2C(t *testing.T, raw string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	client, conn := net.Pipe()
+	s := &Server{handler: echoRequestHandler, h2c: true}
+	go s.handle(conn)
+	t.Cleanup(func() { client.Close() })
+	go io.WriteString(client, raw)
+	return client, bufio.NewReader(client)
+}
+
+// readHandshakeHead reads the lines of an HTTP/1.1 response head.
+func readHandshakeHead(r *bufio.Reader) ([]string, error) {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if line == "\r\n" {
+			return lines, nil
+		}
+		lines = append(lines, line)
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// Preference is one member of an Accept, Accept-Language, Accept-Charset or
+// Accept-Encoding header.
+type Preference struct {
+	// Value is the media range, language range, charset or coding, in
+	// lowercase, with any media type parameters still attached.
+	Value string
+	Q     float64
+}
+
+// ParseAccept parses an Accept-style header value, ranking the members by
+// q-value. Members with the same q-value keep the order they were sent in.
+// A malformed q-value counts as 0.
+func ParseAccept(header string) []Preference {
+	var prefs []Preference
+	for _, member := range strings.Split(header, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		parts := strings.Split(member, ";")
+		pref := Preference{Q: 1}
+		value := []string{strings.TrimSpace(parts[0])}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			name, q, _ := strings.Cut(param, "=")
+			if !strings.EqualFold(strings.TrimSpace(name), "q") {
+				value = append(value, param)
+				continue
+			}
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(q), 64); err == nil && parsed >= 0 && parsed <= 1 {
+				pref.Q = parsed
+			} else {
+				pref.Q = 0
+			}
+			// Parameters after q are accept-extensions and are ignored.
+			break
+		}
+		pref.Value = strings.ToLower(strings.Join(value, ";"))
+		prefs = append(prefs, pref)
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].Q > prefs[j].Q })
+	return prefs
+}
+
+// NegotiateContentType returns the offered media type the Accept value
+// ranks highest, or "" if none is acceptable. An empty Accept accepts the
+// first offer. Ties go to the earlier offer.
+func NegotiateContentType(accept string, offers ...string) string {
+	return negotiate(accept, offers, matchMediaRange)
+}
+
+// NegotiateLanguage returns the offered language tag the Accept-Language
+// value ranks highest, or "" if none is acceptable. A range matches a tag
+// equal to it or starting with it followed by "-", so "en" matches "en-GB".
+func NegotiateLanguage(acceptLanguage string, offers ...string) string {
+	return negotiate(acceptLanguage, offers, matchLanguageRange)
+}
+
+// NegotiateCharset returns the offered charset the Accept-Charset value
+// ranks highest, or "" if none is acceptable.
+func NegotiateCharset(acceptCharset string, offers ...string) string {
+	return negotiate(acceptCharset, offers, matchToken)
+}
+
+// Negotiate picks the media type to answer req with from offers. If the
+// client accepts none of them it writes a 406 response and returns false.
+func Negotiate(w *response.Writer, req *request.Request, offers ...string) (string, bool) {
+	contentType := NegotiateContentType(req.Headers["accept"], offers...)
+	if contentType == "" {
+		hErr := &HandlerError{
+			StatusCode: http.StatusNotAcceptable,
+			Message:    "Available representations: " + strings.Join(offers, ", "),
+		}
+		if err := hErr.Write(w); err != nil {
+			log.Printf("Error writing error response: %v", err)
+		}
+		return "", false
+	}
+	return contentType, true
+}
+
+// matcher reports how specifically a range matches an offer, with higher
+// values for more specific ranges, or -1 if it does not match.
+type matcher func(rng, offer string) int
+
+// negotiate gives each offer the q-value of the most specific range that
+// matches it and returns the offer with the highest non-zero q-value.
+func negotiate(header string, offers []string, match matcher) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if strings.TrimSpace(header) == "" {
+		return offers[0]
+	}
+	prefs := ParseAccept(header)
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, pref := range prefs {
+			if s := match(pref.Value, strings.ToLower(offer)); s > specificity {
+				q, specificity = pref.Q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// matchMediaRange matches "*/*", "type/*" and "type/subtype" ranges. A range
+// with parameters only matches offers that have the same parameters.
+func matchMediaRange(rng, offer string) int {
+	rangeType, rangeParams, err := mime.ParseMediaType(rng)
+	if err != nil {
+		return -1
+	}
+	offerType, offerParams, err := mime.ParseMediaType(offer)
+	if err != nil {
+		return -1
+	}
+	if rangeType == "*/*" {
+		return 0
+	}
+	mainType, subType, _ := strings.Cut(rangeType, "/")
+	if subType == "*" {
+		if strings.HasPrefix(offerType, mainType+"/") {
+			return 1
+		}
+		return -1
+	}
+	if rangeType != offerType {
+		return -1
+	}
+	for name, value := range rangeParams {
+		if !strings.EqualFold(offerParams[name], value) {
+			return -1
+		}
+	}
+	return 2 + len(rangeParams)
+}
+
+// matchLanguageRange implements the basic filtering of RFC 4647 section 3.3.1.
+func matchLanguageRange(rng, offer string) int {
+	switch {
+	case rng == "*":
+		return 0
+	case rng == offer || strings.HasPrefix(offer, rng+"-"):
+		return len(rng)
+	}
+	return -1
+}
+
+// matchToken matches charsets and content codings, which are compared
+// case-insensitively, and the "*" wildcard.
+func matchToken(rng, offer string) int {
+	switch rng {
+	case "*":
+		return 0
+	case offer:
+		return 1
+	}
+	return -1
+}
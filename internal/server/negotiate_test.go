@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccept(t *testing.T) {
+	// Test: Ranked by q-value, stable for ties, parameters kept and extensions dropped
+	prefs := ParseAccept("text/*;q=0.3, Text/HTML;level=1, application/json;q=0.9;ext=1, */*;q=bogus, image/png")
+	assert.Equal(t, []Preference{
+		{Value: "text/html;level=1", Q: 1},
+		{Value: "image/png", Q: 1},
+		{Value: "application/json", Q: 0.9},
+		{Value: "text/*", Q: 0.3},
+		{Value: "*/*", Q: 0},
+	}, prefs)
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name      string
+		negotiate func(string, ...string) string
+		header    string
+		offers    []string
+		expected  string
+	}{
+		{"no header takes the first offer", NegotiateContentType, "", []string{"text/html", "application/json"}, "text/html"},
+		{"highest q wins", NegotiateContentType, "text/html;q=0.5, application/json", []string{"text/html", "application/json"}, "application/json"},
+		{"specific range overrides wildcard", NegotiateContentType, "*/*;q=0.8, text/html;q=0.1", []string{"text/html", "text/plain"}, "text/plain"},
+		{"subtype wildcard", NegotiateContentType, "text/*", []string{"application/json", "text/csv"}, "text/csv"},
+		{"ties go to the earlier offer", NegotiateContentType, "*/*", []string{"text/plain", "text/html"}, "text/plain"},
+		{"q=0 excludes", NegotiateContentType, "text/html;q=0, */*", []string{"text/html"}, ""},
+		{"nothing acceptable", NegotiateContentType, "image/png", []string{"text/html"}, ""},
+		{"media type parameters", NegotiateContentType, "text/html;level=1, text/html;q=0.2", []string{"text/html;level=2", "text/html;level=1"}, "text/html;level=1"},
+		{"language prefix", NegotiateLanguage, "fr-CH, fr;q=0.9, en;q=0.8", []string{"en-GB", "fr-FR"}, "fr-FR"},
+		{"language wildcard", NegotiateLanguage, "de, *;q=0.1", []string{"en", "nl"}, "en"},
+		{"language not matched by a longer range", NegotiateLanguage, "en-GB", []string{"en"}, ""},
+		{"charset case-insensitive", NegotiateCharset, "ISO-8859-1;q=0.5, utf-8", []string{"iso-8859-1", "UTF-8"}, "UTF-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.negotiate(tt.header, tt.offers...))
+		})
+	}
+}
+
+func TestNegotiateNotAcceptable(t *testing.T) {
+	// Test: 406 when no offer is acceptable
+	var buf bytes.Buffer
+	req := &request.Request{Headers: headers.Headers{"accept": "image/png"}}
+	w := &response.Writer{Writer: &buf, RequestHeaders: req.Headers}
+	contentType, ok := Negotiate(w, req, "text/html", "application/json")
+	assert.False(t, ok)
+	assert.Empty(t, contentType)
+	res, err := response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(http.StatusNotAcceptable), res.StatusLine.StatusCode)
+	assert.Equal(t, "text/plain", res.Headers.Get("Content-Type"))
+	assert.Contains(t, string(res.Body), "text/html, application/json")
+
+	// Test: Acceptable offer is returned without writing
+	buf.Reset()
+	req.Headers["accept"] = "application/*"
+	contentType, ok = Negotiate(&response.Writer{Writer: &buf}, req, "text/html", "application/json")
+	assert.True(t, ok)
+	assert.Equal(t, "application/json", contentType)
+	assert.Empty(t, buf.String())
+}
+
+func TestHandlerErrorNegotiation(t *testing.T) {
+	hErr := &HandlerError{StatusCode: http.StatusNotFound, Message: "No <such> page", Headers: headers.Headers{"X-Trace": "1"}}
+	write := func(accept string) *response.Response {
+		var buf bytes.Buffer
+		w := &response.Writer{Writer: &buf}
+		if accept != "" {
+			w.RequestHeaders = headers.Headers{"accept": accept}
+		}
+		require.NoError(t, hErr.Write(w))
+		res, err := response.ResponseFromReader(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, response.StatusCode(http.StatusNotFound), res.StatusLine.StatusCode)
+		assert.Equal(t, "1", res.Headers.Get("X-Trace"))
+		return res
+	}
+
+	// Test: Plain text without an Accept header
+	res := write("")
+	assert.Equal(t, "text/plain", res.Headers.Get("Content-Type"))
+	assert.Equal(t, "No <such> page", string(res.Body))
+	assert.Empty(t, res.Headers.Get("Vary"))
+
+	// Test: HTML for browsers, with the message escaped
+	res = write("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	assert.Equal(t, "text/html", res.Headers.Get("Content-Type"))
+	assert.Equal(t, "Accept", res.Headers.Get("Vary"))
+	assert.Contains(t, string(res.Body), "<h1>404 Not Found</h1>")
+	assert.Contains(t, string(res.Body), "No &lt;such&gt; page")
+
+	// Test: Problem details for JSON clients
+	res = write("application/json")
+	assert.Equal(t, "application/problem+json", res.Headers.Get("Content-Type"))
+	var problem map[string]any
+	require.NoError(t, json.Unmarshal(res.Body, &problem))
+	assert.Equal(t, map[string]any{"title": "Not Found", "status": 404.0, "detail": "No <such> page"}, problem)
+
+	// Test: Plain text when nothing offered is acceptable
+	res = write("image/png")
+	assert.Equal(t, "text/plain", res.Headers.Get("Content-Type"))
+
+	// Test: Error headers replace defaults whatever their case
+	hErr.Headers = headers.Headers{"vary": "Accept, Accept-Language", "content-type": "text/plain; charset=utf-8"}
+	for _, accept := range []string{"text/plain", "application/json"} {
+		var buf bytes.Buffer
+		w := &response.Writer{Writer: &buf, RequestHeaders: headers.Headers{"accept": accept}}
+		require.NoError(t, hErr.Write(w))
+		out := strings.ToLower(buf.String())
+		assert.Equal(t, 1, strings.Count(out, "\r\nvary:"))
+		assert.Equal(t, 1, strings.Count(out, "\r\ncontent-type:"))
+	}
+}
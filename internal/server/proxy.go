@@ -0,0 +1,281 @@
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type ProxyHandler struct {
+	// Transport performs the upstream requests, for example through a
+	// cache. Nil means http.DefaultTransport.
+	Transport http.RoundTripper
+}
+
+// hopByHopHeaders are connection-specific fields that a proxy must not forward.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RequestHandler creates a handler that proxies requests to the specified target.
+// The request body is streamed to the upstream as it arrives, and the
+// upstream body is streamed back, compressed if the client accepts it, and
+// followed by SHA-256 Content-Digest and Repr-Digest trailers over the bytes
+// sent. The X-Content-SHA256 and X-Content-Length trailers that clients of
+// earlier versions read are sent as well.
+func (ph *ProxyHandler) RequestHandler(target string) Handler {
+	return legacyTrailers(DigestTrailers(DigestSHA256, Compress(func(w *response.Writer, req *request.Request) {
+		forward(w, req, "http://httpbin.org"+target, ph.Transport)
+	})))
+}
+
+// legacyTrailers wraps next so that its response body is followed by an
+// X-Content-SHA256 trailer, the hex SHA-256 of the bytes sent, and an
+// X-Content-Length trailer with their count.
+func legacyTrailers(next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method == "HEAD" {
+			next(w, req)
+			return
+		}
+		w.Use(&legacyTrailerTransform{hash: sha256.New()})
+		next(w, req)
+		if err := w.Close(); err != nil {
+			log.Printf("Error finishing response: %v", err)
+		}
+	}
+}
+
+type legacyTrailerTransform struct {
+	hash   hash.Hash
+	length int
+	active bool
+}
+
+func (l *legacyTrailerTransform) TransformHeaders(statusCode response.StatusCode, h headers.Headers) {
+	if (statusCode >= 100 && statusCode < 200) || statusCode == 204 || statusCode == 304 {
+		return
+	}
+	l.active = true
+	h.Del("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	declareTrailers(h, "X-Content-SHA256", "X-Content-Length")
+}
+
+func (l *legacyTrailerTransform) TransformBody(p []byte) ([]byte, error) {
+	l.hash.Write(p)
+	l.length += len(p)
+	return p, nil
+}
+
+func (l *legacyTrailerTransform) Finish(trailer headers.Headers) ([]byte, error) {
+	if !l.active {
+		return nil, nil
+	}
+	trailer.Set("X-Content-SHA256", fmt.Sprintf("%x", l.hash.Sum(nil)))
+	trailer.Set("X-Content-Length", strconv.Itoa(l.length))
+	return nil, nil
+}
+
+// forward sends req to url through transport and streams the upstream
+// response back to w.
+func forward(w *response.Writer, req *request.Request, url string, transport http.RoundTripper) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	upstreamReq, body, err := newUpstreamRequest(req, url)
+	if err != nil {
+		log.Printf("Error building request to %s: %v", url, err)
+		writeError(w, http.StatusBadRequest, "Bad Request")
+		return
+	}
+	if body != nil {
+		// The transport may still be reading the body after RoundTrip
+		// returns. Until it closes the body, the rest of the request cannot
+		// be discarded safely, so the connection is not reused.
+		defer func() {
+			select {
+			case <-body.closed:
+			default:
+				w.KeepAlive = false
+			}
+		}()
+	}
+
+	res, err := transport.RoundTrip(upstreamReq)
+	if err != nil {
+		if bodyErr := body.readErr(); bodyErr != nil {
+			// The client's body failed, not the upstream.
+			WriteBodyError(w, bodyErr)
+			return
+		}
+		log.Printf("Error making request to %s: %v", url, err)
+		writeError(w, http.StatusBadGateway, "Bad Gateway")
+		return
+	}
+	defer res.Body.Close()
+
+	if err := w.WriteStatusLine(response.StatusCode(res.StatusCode)); err != nil {
+		log.Printf("Error writing status line: %v", err)
+		return
+	}
+
+	if err := w.WriteHeaders(downstreamHeaders(res, req.RequestLine.Method)); err != nil {
+		log.Printf("Error writing headers: %v", err)
+		return
+	}
+
+	if _, err := streamResponseBody(res.Body, w); err != nil {
+		log.Printf("Error streaming response body: %v", err)
+	}
+}
+
+// newUpstreamRequest builds the request sent to url, forwarding the method,
+// end-to-end headers and a streaming view of the client's body. The view is
+// returned as well, or nil if the request has no body.
+func newUpstreamRequest(req *request.Request, url string) (*http.Request, *upstreamBody, error) {
+	var body *upstreamBody
+	reader := io.Reader(http.NoBody)
+	if req.HasBody() {
+		body = &upstreamBody{Reader: req.BodyReader(), closed: make(chan struct{})}
+		reader = body
+	}
+
+	upstreamReq, err := http.NewRequest(req.RequestLine.Method, url, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for key, value := range req.Headers {
+		if key == "host" || key == "content-length" || isHopByHop(key) {
+			continue
+		}
+		upstreamReq.Header.Set(key, value)
+	}
+
+	// An unknown length makes the transport send the body chunked.
+	upstreamReq.ContentLength = -1
+	if cl := req.Headers["content-length"]; cl != "" {
+		if upstreamReq.ContentLength, err = strconv.ParseInt(cl, 10, 64); err != nil {
+			return nil, nil, err
+		}
+	} else if !req.HasBody() {
+		upstreamReq.ContentLength = 0
+	}
+
+	return upstreamReq, body, nil
+}
+
+// upstreamBody is the client's body as read by the transport, which closes
+// it once it is done with it.
+type upstreamBody struct {
+	io.Reader
+	once   sync.Once
+	closed chan struct{}
+	mu     sync.Mutex
+	err    error
+}
+
+func (b *upstreamBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		b.mu.Lock()
+		b.err = err
+		b.mu.Unlock()
+	}
+	return n, err
+}
+
+// readErr returns the error reading the client's body failed with, if any.
+func (b *upstreamBody) readErr() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+func (b *upstreamBody) Close() error {
+	b.once.Do(func() { close(b.closed) })
+	return nil
+}
+
+// downstreamHeaders copies the end-to-end headers of the upstream response.
+// A response that can have a body is streamed chunked; one that cannot,
+// such as a 304 or the answer to a HEAD request, keeps its Content-Length.
+func downstreamHeaders(res *http.Response, method string) headers.Headers {
+	h := headers.NewHeaders()
+	for key, values := range res.Header {
+		if key == "Content-Length" || isHopByHop(key) {
+			continue
+		}
+		for _, value := range values {
+			h.Add(key, value)
+		}
+	}
+	bodyless := method == "HEAD" || res.StatusCode < 200 || res.StatusCode == 204 || res.StatusCode == 304
+	if !bodyless {
+		h.Set("Transfer-Encoding", "chunked")
+	} else if cl := res.Header.Get("Content-Length"); cl != "" && res.StatusCode != 204 {
+		h.Set("Content-Length", cl)
+	}
+	return h
+}
+
+func isHopByHop(key string) bool {
+	for _, name := range hopByHopHeaders {
+		if strings.EqualFold(name, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamResponseBody streams the response body in chunks and ends it. Each
+// chunk is written before the next is read, so a slow client slows the
+// upstream read rather than growing a buffer.
+func streamResponseBody(body io.Reader, w *response.Writer) (int, error) {
+	buf := make([]byte, 1024)
+	totalBytes := 0
+
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			totalBytes += n
+			if _, writeErr := w.WriteChunkerBody(buf[:n]); writeErr != nil {
+				return totalBytes, writeErr
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return totalBytes, err
+		}
+	}
+
+	if _, err := w.WriteChunkedDone(); err != nil {
+		return totalBytes, err
+	}
+
+	return totalBytes, nil
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upstreamFunc adapts a function into an http.RoundTripper standing in for the upstream.
+type upstreamFunc func(req *http.Request) *http.Response
+
+func (f upstreamFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+func upstreamResponse(status int, header http.Header, body string) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+// proxyWrite sends raw through the proxy handler and returns what it writes.
+func proxyWrite(t *testing.T, ph *ProxyHandler, raw string) (*response.Writer, string) {
+	t.Helper()
+	req, err := request.RequestHeadFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := &response.Writer{Writer: &buf, KeepAlive: true}
+	ph.RequestHandler(req.Target.Path)(w, req)
+	return w, buf.String()
+}
+
+// proxyRoundTrip sends raw through the proxy handler and parses what it writes.
+func proxyRoundTrip(t *testing.T, ph *ProxyHandler, raw string) *response.Response {
+	t.Helper()
+	_, out := proxyWrite(t, ph, raw)
+	res, err := response.ResponseFromReader(strings.NewReader(out))
+	require.NoError(t, err)
+	return res
+}
+
+func TestProxyTrailers(t *testing.T) {
+	ph := &ProxyHandler{Transport: upstreamFunc(func(req *http.Request) *http.Response {
+		return upstreamResponse(200, http.Header{"Content-Type": {"text/plain"}}, "hello world!")
+	})}
+
+	// Test: Digest and compatibility trailers follow the body
+	res := proxyRoundTrip(t, ph, "GET /get HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	sum := sha256.Sum256([]byte("hello world!"))
+	assert.Equal(t, "hello world!", string(res.Body))
+	assert.Equal(t, "Content-Digest, Repr-Digest, X-Content-SHA256, X-Content-Length", res.Headers.Get("Trailer"))
+	assert.NotEmpty(t, res.Trailers.Get("Content-Digest"))
+	assert.Equal(t, fmt.Sprintf("%x", sum), res.Trailers.Get("X-Content-SHA256"))
+	assert.Equal(t, "12", res.Trailers.Get("X-Content-Length"))
+
+	// Test: Under compression the compatibility trailers cover the bytes sent
+	res = proxyRoundTrip(t, ph, "GET /get HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n")
+	sum = sha256.Sum256(res.Body)
+	assert.Equal(t, "gzip", res.Headers.Get("Content-Encoding"))
+	assert.Equal(t, fmt.Sprintf("%x", sum), res.Trailers.Get("X-Content-SHA256"))
+	assert.Equal(t, fmt.Sprint(len(res.Body)), res.Trailers.Get("X-Content-Length"))
+}
+
+func TestProxyFraming(t *testing.T) {
+	var status int
+	ph := &ProxyHandler{Transport: upstreamFunc(func(req *http.Request) *http.Response {
+		res := upstreamResponse(status, http.Header{"Content-Length": {"12"}, "Etag": {`"v1"`}}, "")
+		res.Body = http.NoBody
+		return res
+	})}
+
+	// Test: A 304 keeps its headers and gets no body framing
+	status = http.StatusNotModified
+	w, out := proxyWrite(t, ph, "GET /etag HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, out, "Etag: \"v1\"\r\n")
+	assert.NotContains(t, out, "Transfer-Encoding")
+	assert.NotContains(t, out, "Connection")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+	assert.True(t, w.KeepAlive)
+
+	// Test: The answer to HEAD keeps the upstream Content-Length
+	status = http.StatusOK
+	w, out = proxyWrite(t, ph, "HEAD /get HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, out, "Content-Length: 12\r\n")
+	assert.NotContains(t, out, "Transfer-Encoding")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+	assert.True(t, w.KeepAlive)
+}
+
+func TestProxyRequestBody(t *testing.T) {
+	var received string
+	closeBody := true
+	ph := &ProxyHandler{Transport: upstreamFunc(func(req *http.Request) *http.Response {
+		body, _ := io.ReadAll(req.Body)
+		received = string(body)
+		if closeBody {
+			req.Body.Close()
+		}
+		return upstreamResponse(200, http.Header{}, "ok")
+	})}
+	raw := "POST /post HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"
+
+	// Test: The body reaches the upstream and the connection can be reused
+	w, _ := proxyWrite(t, ph, raw)
+	assert.Equal(t, "hello", received)
+	assert.True(t, w.KeepAlive)
+
+	// Test: A body the transport has not closed may still be in use
+	closeBody = false
+	w, _ = proxyWrite(t, ph, raw)
+	assert.False(t, w.KeepAlive)
+}
@@ -1,80 +1,122 @@
 package server
 
 import (
-	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"html"
+	"httpfromtcp/internal/headers"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
 	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+// keepAliveTimeout is how long an idle connection waits for its next request.
+const keepAliveTimeout = 5 * time.Second
+
+// handshakeTimeout bounds the TLS handshake of a new connection.
+var handshakeTimeout = 10 * time.Second
+
 type Server struct {
-	handler  Handler
-	listener net.Listener
-	closed   atomic.Bool
-	certFile string
-	keyFile  string
+	handler      Handler
+	listener     net.Listener
+	closed       atomic.Bool
+	certs        *CertStore
+	certReload   bool
+	certPoll     time.Duration
+	clientCAFile string
+	requireCert  bool
+	clientCAs    *x509.CertPool
+	done         chan struct{}
+	streamBodies bool
+	decodeLimit  int64
+	h2c          bool
+	parseOpts    []request.Option
 }
 
-type Handler func(*response.Writer, *request.Request)
+// Option configures optional Server behaviour.
+type Option func(*Server)
 
-type ProxyHandler struct{}
+// WithStreamingBodies stops the server from reading request bodies before
+// calling the handler. Handlers must read the body with Request.BodyReader.
+func WithStreamingBodies() Option {
+	return func(s *Server) {
+		s.streamBodies = true
+	}
+}
 
-type HandlerError struct {
-	StatusCode int
-	Message    string
+// WithRequestDecoding makes the server remove gzip and deflate
+// Content-Encoding from request bodies before the handler sees them, allowing
+// at most limit decoded bytes. Other encodings are answered with 415, and
+// bodies that decode past the limit with 413. A streamed body only reaches
+// the limit as the handler reads it; see WriteBodyError.
+func WithRequestDecoding(limit int64) Option {
+	return func(s *Server) {
+		s.decodeLimit = limit
+	}
 }
 
-// RequestHandler creates a handler that proxies requests to the specified target.
-func (ph *ProxyHandler) RequestHandler(target string) Handler {
-	return func(w *response.Writer, req *request.Request) {
-		res, err := http.Get("http://httpbin.org" + target)
-		if err != nil {
-			log.Printf("Error making request to %s: %v", target, err)
-			writeError(w, http.StatusInternalServerError, "Internal Server Error")
-			return
-		}
-		defer res.Body.Close()
+// WithStrictParsing rejects requests that do not follow the RFC 9112 grammar
+// exactly, instead of accepting the variations older clients send.
+func WithStrictParsing() Option {
+	return func(s *Server) {
+		s.parseOpts = append(s.parseOpts, request.Strict())
+	}
+}
 
-		headers := response.GetDefaultHeaders(0)
-		delete(headers, "Content-Length")
-		headers["Transfer-Encoding"] = "chunked"
-		headers["Trailer"] = "X-Content-SHA256, X-Content-Length"
+// WithH2C lets clients speak HTTP/2 without TLS, either by opening the
+// connection with the HTTP/2 preface or by sending "Upgrade: h2c". Only
+// enable it behind a proxy that strips Upgrade headers from untrusted clients.
+func WithH2C() Option {
+	return func(s *Server) {
+		s.h2c = true
+	}
+}
 
-		if err := w.WriteStatusLine(response.StatusCode(res.StatusCode)); err != nil {
-			log.Printf("Error writing status line: %v", err)
-			return
-		}
+// WithCertReload makes a TLS server reload its certificates when the process
+// receives SIGHUP and, if interval is positive, when the certificate files
+// change, checking every interval. If the new certificates cannot be loaded
+// the error is logged and the previous ones stay in service.
+func WithCertReload(interval time.Duration) Option {
+	return func(s *Server) {
+		s.certReload = true
+		s.certPoll = interval
+	}
+}
 
-		if err := w.WriteHeaders(headers); err != nil {
-			log.Printf("Error writing headers: %v", err)
-			return
-		}
+// WithClientAuth makes a TLS server verify client certificates against the
+// CAs in the PEM bundle caFile. If required is true, clients without a valid
+// certificate are refused during the handshake; otherwise only certificates
+// that are sent are verified. Handlers find the client's identity in
+// Request.TLS.Peer.
+func WithClientAuth(caFile string, required bool) Option {
+	return func(s *Server) {
+		s.clientCAFile = caFile
+		s.requireCert = required
+	}
+}
 
-		hash := sha256.New()
-		totalBytes, err := streamResponseBody(res.Body, w, hash)
-		if err != nil {
-			log.Printf("Error streaming response body: %v", err)
-			return
-		}
+type Handler func(*response.Writer, *request.Request)
 
-		trailer := map[string]string{
-			"X-Content-SHA256": fmt.Sprintf("%x", hash.Sum(nil)),
-			"X-Content-Length": fmt.Sprintf("%d", totalBytes),
-		}
-		if err := w.WriteTrailer(trailer); err != nil {
-			log.Printf("Error writing trailer: %v", err)
-		}
-	}
+type HandlerError struct {
+	StatusCode int
+	Message    string
+	// Headers are added to the default headers of the error response.
+	Headers headers.Headers
 }
 
 // Serve starts the server on the specified port and begins listening for connections.
-func Serve(port int, handler Handler) (*Server, error) {
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
@@ -83,20 +125,62 @@ func Serve(port int, handler Handler) (*Server, error) {
 		handler:  handler,
 		listener: listener,
 	}
+	for _, opt := range opts {
+		opt(server)
+	}
 	go server.listen()
 	return server, nil
 }
 
-func ServeTLS(port int, handler Handler, cert string, key string) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// ServeTLS starts the server on the specified port, serving TLS with the key
+// pair in the cert and key files.
+func ServeTLS(port int, handler Handler, cert string, key string, opts ...Option) (*Server, error) {
+	certs, err := LoadCertFiles(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	return serveTLS(port, handler, certs, opts)
+}
+
+// ServeTLSDir is like ServeTLS but serves every certificate in dir, picking
+// one for each connection by the server name the client asks for. See
+// LoadCertDir for the layout of dir.
+func ServeTLSDir(port int, handler Handler, dir string, opts ...Option) (*Server, error) {
+	certs, err := LoadCertDir(dir)
 	if err != nil {
 		return nil, err
 	}
+	return serveTLS(port, handler, certs, opts)
+}
+
+func serveTLS(port int, handler Handler, certs *CertStore, opts []Option) (*Server, error) {
 	server := &Server{
-		handler:  handler,
-		listener: listener,
-		certFile: cert,
-		keyFile:  key,
+		handler: handler,
+		certs:   certs,
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(server)
+	}
+	if server.clientCAFile != "" {
+		pool, err := loadCAPool(server.clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		server.clientCAs = pool
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	server.listener = listener
+	if server.certReload {
+		// Subscribe before returning, so a SIGHUP sent once ServeTLS has
+		// returned cannot take the default action of ending the process.
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go server.watchCertificates(hup)
 	}
 	go server.listenTLS()
 	return server, nil
@@ -107,6 +191,9 @@ func (s *Server) Close() error {
 	if s.closed.Swap(true) {
 		return nil
 	}
+	if s.done != nil {
+		close(s.done)
+	}
 	return s.listener.Close()
 }
 
@@ -126,13 +213,16 @@ func (s *Server) listen() {
 }
 
 func (s *Server) listenTLS() {
-	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
-	if err != nil {
-		log.Fatalf("Error loading TLS certificate: %v", err)
-	}
-
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
+		GetCertificate: s.certs.GetCertificate,
+		NextProtos:     nextProtos,
+	}
+	if s.clientCAs != nil {
+		tlsConfig.ClientCAs = s.clientCAs
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if s.requireCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
 	}
 
 	tlsListener := tls.NewListener(s.listener, tlsConfig)
@@ -149,18 +239,139 @@ func (s *Server) listenTLS() {
 	}
 }
 
-// handle processes a single connection by sending a fixed HTTP response.
-func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
+// watchCertificates reloads the certificates on each signal from hup and,
+// with WithCertReload given an interval, whenever the files change, until
+// the server is closed.
+func (s *Server) watchCertificates(hup chan os.Signal) {
+	defer signal.Stop(hup)
+	var tick <-chan time.Time
+	if s.certPoll > 0 {
+		ticker := time.NewTicker(s.certPoll)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-hup:
+			s.reloadCertificates()
+		case <-tick:
+			if s.certs.Changed() {
+				s.reloadCertificates()
+			}
+		}
+	}
+}
+
+func (s *Server) reloadCertificates() {
+	if err := s.certs.Reload(); err != nil {
+		log.Printf("Error reloading TLS certificates, keeping the previous ones: %v", err)
+		return
+	}
+	log.Printf("Reloaded TLS certificates")
+}
 
-	req, err := request.RequestFromReader(conn)
+// handle serves the requests sent on a connection, one after another, for
+// as long as both sides want to keep it open. The connection is closed
+// afterwards unless a handler hijacked it. Connections that turn out to be
+// HTTP/2 are handed to serveHTTP2 instead.
+func (s *Server) handle(netConn net.Conn) {
+	netConn, isHTTP2, err := s.detectHTTP2(netConn)
 	if err != nil {
-		writeError(&response.Writer{Writer: conn}, http.StatusBadRequest, err.Error())
+		netConn.Close()
 		return
 	}
+	var tlsInfo *request.TLSInfo
+	if tlsConn, ok := netConn.(*tls.Conn); ok {
+		tlsInfo = request.NewTLSInfo(tlsConn.ConnectionState())
+	}
+	if isHTTP2 {
+		s.serveHTTP2(netConn, tlsInfo)
+		return
+	}
+
+	// Bytes read past the end of one request are the start of the next.
+	reader := &prefixedConn{Conn: netConn}
+	conn := &conn{Conn: reader, tls: tlsInfo}
+	defer func() {
+		if !conn.hijacked.Load() {
+			netConn.Close()
+		}
+	}()
+
+	for first := true; ; first = false {
+		if !first {
+			netConn.SetReadDeadline(time.Now().Add(keepAliveTimeout))
+		}
+		req, err := request.RequestHeadFromReader(reader, s.parseOpts...)
+		if !first && (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, os.ErrDeadlineExceeded)) {
+			return // The client is done with the connection.
+		}
+		netConn.SetReadDeadline(time.Time{})
+		if !s.serveRequest(conn, req, err) {
+			return
+		}
+		reader.prefix = append(req.Buffered(), reader.prefix...)
+	}
+}
+
+// serveRequest answers one request whose head was read with err, and
+// reports whether the connection can carry another request.
+func (s *Server) serveRequest(conn *conn, req *request.Request, err error) bool {
+	// Check Host before reading the body, so that a request refused for it
+	// costs no more than its head.
+	if err == nil {
+		err = checkHost(req)
+	}
+	if err == nil && !s.streamBodies {
+		err = req.ReadBody()
+	}
+	if err != nil {
+		// Never keep a connection whose request framing was in doubt.
+		statusCode := http.StatusBadRequest
+		switch {
+		case errors.Is(err, request.ErrUnsupportedVersion):
+			statusCode = http.StatusHTTPVersionNotSupported
+		case errors.Is(err, request.ErrUnsupportedTransferCoding):
+			statusCode = http.StatusNotImplemented
+		}
+		hErr := &HandlerError{StatusCode: statusCode, Message: err.Error(), Headers: headers.Headers{"Connection": "close"}}
+		if err := hErr.Write(&response.Writer{Writer: conn}); err != nil {
+			log.Printf("Error writing error response: %v", err)
+		}
+		return false
+	}
+	req.TLS = conn.tls
+
+	if s.h2c && isH2CUpgrade(req) {
+		s.upgradeH2C(conn, req)
+		return false
+	}
+
+	version := "1.1"
+	if req.RequestLine.HTTPVersion == "1.0" {
+		version = "1.0"
+	}
+	writer := &response.Writer{Writer: conn, Version: version, KeepAlive: req.KeepAlive(), RequestHeaders: req.Headers}
 
-	writer := &response.Writer{Writer: conn}
+	if s.decodeLimit > 0 {
+		if err := req.DecodeContentEncoding(s.decodeLimit); err != nil {
+			WriteBodyError(writer, err)
+			return false
+		}
+	}
+
+	conn.req = req
 	s.handler(writer, req)
+	if conn.hijacked.Load() {
+		return false
+	}
+	conn.stopWatch()
+	if err := writer.Close(); err != nil {
+		return false
+	}
+	return writer.KeepAlive && writer.State == response.WriterDone && req.DiscardBody() == nil
 }
 
 // writeError writes an error response to the client.
@@ -174,48 +385,82 @@ func writeError(w *response.Writer, statusCode int, message string) {
 	}
 }
 
-// Write writes the error response to the client.
+// WriteBodyError answers a request whose body could not be read or decoded:
+// 415 for an unsupported Content-Encoding, 413 once the decoded body grows
+// past the WithRequestDecoding limit and 400 otherwise. With
+// WithStreamingBodies the limit is only reached as the handler reads the
+// body, so handlers should pass their read errors here. The rest of the body
+// is left unread, so the connection is closed after the response.
+func WriteBodyError(w *response.Writer, err error) {
+	hErr := &HandlerError{StatusCode: http.StatusBadRequest, Message: err.Error(), Headers: headers.Headers{"Connection": "close"}}
+	switch {
+	case errors.Is(err, request.ErrUnsupportedEncoding):
+		hErr.StatusCode = http.StatusUnsupportedMediaType
+		hErr.Headers["Accept-Encoding"] = strings.Join(request.SupportedEncodings, ", ")
+	case errors.Is(err, request.ErrBodyTooLarge):
+		hErr.StatusCode = http.StatusRequestEntityTooLarge
+	}
+	if err := hErr.Write(w); err != nil {
+		log.Printf("Error writing error response: %v", err)
+	}
+}
+
+// errorTypes are the representations of an error response. The first is
+// sent to clients without an Accept header or that accept none of them.
+var errorTypes = []string{"text/plain", "text/html", "application/problem+json", "application/json"}
+
+// Write writes the error response to the client as plain text, HTML or
+// problem+json, whichever the request's Accept header ranks highest.
 func (he *HandlerError) Write(w *response.Writer) error {
+	accept := w.RequestHeaders.Get("Accept")
+	contentType := NegotiateContentType(accept, errorTypes...)
+	if contentType == "" {
+		contentType = errorTypes[0]
+	}
+
+	extra := headers.Headers{}
+	if accept != "" {
+		extra["Vary"] = "Accept"
+	}
+	for name, value := range he.Headers {
+		extra.Set(name, value)
+	}
+	if contentType == "application/problem+json" || contentType == "application/json" {
+		return w.WriteProblem(he.problem(), extra)
+	}
+
+	body := []byte(he.Message)
+	if contentType == "text/html" {
+		title := html.EscapeString(fmt.Sprintf("%d %s", he.StatusCode, http.StatusText(he.StatusCode)))
+		body = []byte("<html>\n<head><title>" + title + "</title></head>\n<body>\n<h1>" + title +
+			"</h1>\n<p>" + html.EscapeString(he.Message) + "</p>\n</body>\n</html>\n")
+	}
 	if err := w.WriteStatusLine(response.StatusCode(he.StatusCode)); err != nil {
 		return err
 	}
-
-	headers := response.GetDefaultHeaders(len(he.Message))
+	headers := response.GetDefaultHeaders(len(body))
+	headers["Content-Type"] = contentType
+	for name, value := range extra {
+		headers.Set(name, value)
+	}
 	if err := w.WriteHeaders(headers); err != nil {
 		return err
 	}
 
-	_, err := w.Write([]byte(he.Message))
+	_, err := w.WriteBody(body)
 	return err
 }
 
-// streamResponseBody streams the response body in chunks and calculates its SHA256 hash.
-func streamResponseBody(body io.Reader, w *response.Writer, hash io.Writer) (int, error) {
-	buf := make([]byte, 1024)
-	totalBytes := 0
-
-	for {
-		n, err := body.Read(buf)
-		if n > 0 {
-			totalBytes += n
-			if _, writeErr := w.WriteChunkerBody(buf[:n]); writeErr != nil {
-				return totalBytes, writeErr
-			}
-			if _, hashErr := hash.Write(buf[:n]); hashErr != nil {
-				return totalBytes, hashErr
-			}
-		}
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return totalBytes, err
-		}
-	}
+// WriteProblem writes the error as an RFC 9457 application/problem+json
+// response, with the message as its detail.
+func (he *HandlerError) WriteProblem(w *response.Writer) error {
+	return w.WriteProblem(he.problem(), he.Headers)
+}
 
-	if _, err := w.WriteChunkedDone(); err != nil {
-		return totalBytes, err
+func (he *HandlerError) problem() response.Problem {
+	return response.Problem{
+		Title:  http.StatusText(he.StatusCode),
+		Status: he.StatusCode,
+		Detail: he.Message,
 	}
-
-	return totalBytes, nil
 }
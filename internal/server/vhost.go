@@ -0,0 +1,126 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// HostMux dispatches requests to handlers by the host they are addressed to.
+type HostMux struct {
+	// Default handles requests for hosts without a handler of their own.
+	// If it is nil they are answered with 421 Misdirected Request.
+	Default Handler
+	exact   map[string]Handler
+	// wildcards is keyed by the suffix of the pattern, such as ".example.com".
+	wildcards map[string]Handler
+}
+
+// Handle registers handler for pattern: a host name such as "example.com",
+// or "*.example.com" for every subdomain of example.com at any depth, but
+// not example.com itself. Names are matched case-insensitively and without
+// the port. Exact names take precedence, then the longest wildcard. Handle
+// panics on an invalid or duplicate pattern.
+func (m *HostMux) Handle(pattern string, handler Handler) {
+	name := normalizeHost(pattern)
+	wildcard := strings.HasPrefix(name, "*.")
+	if wildcard {
+		name = name[1:]
+	}
+	if name == "" || strings.ContainsAny(name, "*:/") {
+		panic(fmt.Sprintf("server: invalid host pattern %q", pattern))
+	}
+
+	table := &m.exact
+	if wildcard {
+		table = &m.wildcards
+	}
+	if *table == nil {
+		*table = make(map[string]Handler)
+	}
+	if _, exists := (*table)[name]; exists {
+		panic(fmt.Sprintf("server: duplicate host pattern %q", pattern))
+	}
+	(*table)[name] = handler
+}
+
+// ServeRequest passes the request to the handler registered for its host.
+func (m *HostMux) ServeRequest(w *response.Writer, req *request.Request) {
+	if handler := m.match(RequestHost(req)); handler != nil {
+		handler(w, req)
+		return
+	}
+	if m.Default != nil {
+		m.Default(w, req)
+		return
+	}
+	writeError(w, http.StatusMisdirectedRequest, "Unknown host")
+}
+
+func (m *HostMux) match(host string) Handler {
+	if host == "" {
+		return nil
+	}
+	if handler, ok := m.exact[host]; ok {
+		return handler
+	}
+	// Try ever shorter suffixes, so the longest wildcard wins.
+	for i := strings.IndexByte(host, '.'); i >= 0; {
+		if handler, ok := m.wildcards[host[i:]]; ok {
+			return handler
+		}
+		next := strings.IndexByte(host[i+1:], '.')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return nil
+}
+
+// RequestHost returns the host a request is addressed to, in lowercase and
+// without a port: the host of an absolute-form target, which takes
+// precedence over the Host header (RFC 9112 section 3.2.2), or the Host
+// header. HTTP/2 requests carry :authority in the Host header.
+func RequestHost(req *request.Request) string {
+	if req.Target.Form == request.AbsoluteForm {
+		return normalizeHost(req.Target.Host)
+	}
+	return normalizeHost(req.Headers["host"])
+}
+
+// normalizeHost lowercases host and removes the port, the brackets of an
+// IPv6 literal and a trailing dot.
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimPrefix(strings.TrimSuffix(host, "]"), "[")
+	return strings.TrimSuffix(host, ".")
+}
+
+// checkHost enforces RFC 9112 section 3.2: an HTTP/1.1 request must carry
+// exactly one Host header, and any Host header must be a valid authority.
+func checkHost(req *request.Request) error {
+	host, ok := req.Headers["host"]
+	if !ok {
+		if req.RequestLine.HTTPVersion == "1.1" {
+			return errors.New("missing Host header")
+		}
+		return nil
+	}
+	// Host values cannot contain commas, so one means the field was repeated.
+	if strings.Contains(host, ",") {
+		return errors.New("multiple Host headers")
+	}
+	if strings.ContainsAny(host, " \t/\\?#@") {
+		return fmt.Errorf("invalid Host header %q", host)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namedHandler answers with its name in the X-Handler header.
+func namedHandler(name string) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(headers.Headers{"Content-Length": "0", "X-Handler": name})
+	}
+}
+
+func TestHostMux(t *testing.T) {
+	mux := &HostMux{}
+	mux.Handle("Example.com", namedHandler("apex"))
+	mux.Handle("*.example.com", namedHandler("wildcard"))
+	mux.Handle("*.api.example.com", namedHandler("api"))
+	mux.Handle("www.example.com", namedHandler("www"))
+
+	serve := func(req *request.Request) *response.Response {
+		var buf bytes.Buffer
+		mux.ServeRequest(&response.Writer{Writer: &buf}, req)
+		res, err := response.ResponseFromReader(&buf)
+		require.NoError(t, err)
+		return res
+	}
+	byHost := func(host string) string {
+		return serve(&request.Request{Headers: headers.Headers{"host": host}}).Headers.Get("X-Handler")
+	}
+
+	// Test: Exact names win over wildcards, ignoring case, port and trailing dot
+	assert.Equal(t, "apex", byHost("example.com"))
+	assert.Equal(t, "apex", byHost("EXAMPLE.com:8443"))
+	assert.Equal(t, "www", byHost("www.example.com."))
+
+	// Test: Wildcards match subdomains at any depth, the longest first
+	assert.Equal(t, "wildcard", byHost("shop.example.com"))
+	assert.Equal(t, "wildcard", byHost("a.b.example.com"))
+	assert.Equal(t, "api", byHost("v1.api.example.com"))
+	assert.Equal(t, "wildcard", byHost("api.example.com"))
+
+	// Test: Absolute-form target takes precedence over Host
+	target, err := request.ParseTarget("GET", "http://www.example.com/")
+	require.NoError(t, err)
+	res := serve(&request.Request{Target: target, Headers: headers.Headers{"host": "other.test"}})
+	assert.Equal(t, "www", res.Headers.Get("X-Handler"))
+
+	// Test: Unknown hosts get 421 without a default
+	res = serve(&request.Request{Headers: headers.Headers{"host": "example.org"}})
+	assert.Equal(t, response.StatusCode(http.StatusMisdirectedRequest), res.StatusLine.StatusCode)
+
+	// Test: And go to the default when there is one
+	mux.Default = namedHandler("default")
+	assert.Equal(t, "default", byHost("example.org"))
+	assert.Equal(t, "default", byHost(""))
+
+	// Test: Invalid and duplicate patterns
+	assert.Panics(t, func() { mux.Handle("example.com", namedHandler("again")) })
+	assert.Panics(t, func() { mux.Handle("*.example.com", namedHandler("again")) })
+	assert.Panics(t, func() { mux.Handle("a.*.example.com", namedHandler("bad")) })
+	assert.Panics(t, func() { mux.Handle("", namedHandler("bad")) })
+}
+
+func TestHostHeaderRequired(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		status response.StatusCode
+	}{
+		{"HTTP/1.1 with Host", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", 200},
+		{"HTTP/1.1 without Host", "GET / HTTP/1.1\r\n\r\n", 400},
+		{"HTTP/1.1 with two Hosts", "GET / HTTP/1.1\r\nHost: a.example\r\nHost: b.example\r\n\r\n", 400},
+		{"HTTP/1.1 with an invalid Host", "GET / HTTP/1.1\r\nHost: a.example/path\r\n\r\n", 400},
+		{"HTTP/1.1 absolute form still needs Host", "GET http://example.com/ HTTP/1.1\r\n\r\n", 400},
+		{"HTTP/1.0 without Host", "GET / HTTP/1.0\r\n\r\n", 200},
+		// The body never arrives: the request is refused before reading it.
+		{"HTTP/1.1 without Host is refused before its body", "POST / HTTP/1.1\r\nContent-Length: 100\r\n\r\n", 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, reader := roundTrip(t, namedHandler("ok"), tt.raw)
+			client.SetDeadline(time.Now().Add(time.Second))
+			res, err := response.ResponseFromReader(reader)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, res.StatusLine.StatusCode)
+		})
+	}
+}
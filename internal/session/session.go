@@ -0,0 +1,295 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+const (
+	defaultCookieName      = "session"
+	defaultIdleTimeout     = 30 * time.Minute
+	defaultAbsoluteTimeout = 24 * time.Hour
+	idLength               = 32
+)
+
+// Record is the data a Store keeps for a session.
+type Record struct {
+	ID      string            `json:"id"`
+	Values  map[string]string `json:"v,omitempty"`
+	Created time.Time         `json:"c"`
+	// Seen is when the session was last used.
+	Seen time.Time `json:"s"`
+}
+
+// Store saves sessions and finds them again from the cookie value it issued.
+type Store interface {
+	// Load returns the session a cookie value refers to, or false if there
+	// is none or the value was not issued by the store.
+	Load(value string) (Record, bool)
+	// Save stores r until expires and returns the value for the cookie.
+	Save(r Record, expires time.Time) (string, error)
+	// Delete forgets the session with the given ID.
+	Delete(id string)
+}
+
+// Session is the session of one request. A handler reads and changes it
+// through Manager.Get; it is saved when the response headers are written.
+type Session struct {
+	record    Record
+	isNew     bool
+	modified  bool
+	destroyed bool
+	// previousID is the ID to delete from the store after Renew.
+	previousID string
+}
+
+// ID returns the session ID, which changes when the session is renewed.
+func (s *Session) ID() string {
+	return s.record.ID
+}
+
+// IsNew reports whether the client did not send a valid session.
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+// Get returns the value stored under key, or "" if there is none.
+func (s *Session) Get(key string) string {
+	return s.record.Values[key]
+}
+
+// Set stores value under key.
+func (s *Session) Set(key, value string) {
+	if s.record.Values == nil {
+		s.record.Values = make(map[string]string)
+	}
+	s.record.Values[key] = value
+	s.modified = true
+}
+
+// Delete removes key.
+func (s *Session) Delete(key string) {
+	if _, ok := s.record.Values[key]; ok {
+		delete(s.record.Values, key)
+		s.modified = true
+	}
+}
+
+// Renew gives the session a new ID and discards the old one, keeping its
+// values. Call it whenever the privilege level changes, such as on login,
+// so that an ID planted by an attacker before login is worthless after it.
+func (s *Session) Renew() {
+	if s.previousID == "" && !s.isNew {
+		s.previousID = s.record.ID
+	}
+	s.record.ID = newID()
+	s.modified = true
+}
+
+// Destroy ends the session: it is removed from the store and the client is
+// told to delete the cookie.
+func (s *Session) Destroy() {
+	s.destroyed = true
+}
+
+// Manager loads and saves the sessions of the requests passing through its
+// Middleware.
+type Manager struct {
+	Store Store
+	// CookieName is the name of the session cookie, "session" if empty.
+	CookieName string
+	// IdleTimeout ends a session that has not been used for this long, 30
+	// minutes if zero.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends a session this long after it was created,
+	// however active it is, 24 hours if zero.
+	AbsoluteTimeout time.Duration
+	// Cookie holds the attributes of the session cookie. Its Name, Value,
+	// Expires and MaxAge are ignored. Path "/" is used if it has none.
+	Cookie cookie.Cookie
+	// now is replaced in tests.
+	now func() time.Time
+
+	mu       sync.Mutex
+	sessions map[*request.Request]*Session
+}
+
+// NewManager returns a manager for sessions kept in store, with secure
+// cookie defaults: HttpOnly, Secure and SameSite=Lax.
+func NewManager(store Store) *Manager {
+	return &Manager{
+		Store:  store,
+		Cookie: cookie.Cookie{Path: "/", HttpOnly: true, Secure: true, SameSite: cookie.SameSiteLax},
+	}
+}
+
+// Get returns the session of a request being handled inside Middleware, or
+// nil for any other request.
+func (m *Manager) Get(req *request.Request) *Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[req]
+}
+
+// Middleware loads the session of each request before calling next and
+// saves it, setting the cookie, when next writes the response headers.
+func (m *Manager) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		s := m.load(req)
+		m.mu.Lock()
+		if m.sessions == nil {
+			m.sessions = make(map[*request.Request]*Session)
+		}
+		m.sessions[req] = s
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			delete(m.sessions, req)
+			m.mu.Unlock()
+		}()
+
+		w.Use(&sessionTransform{manager: m, session: s})
+		next(w, req)
+	}
+}
+
+// load returns the request's session, or a new one if it has none or it
+// has expired.
+func (m *Manager) load(req *request.Request) *Session {
+	now := m.clock()
+	if value, ok := req.Cookie(m.cookieName()); ok {
+		if record, ok := m.Store.Load(value); ok {
+			if now.Sub(record.Seen) < m.idleTimeout() && now.Sub(record.Created) < m.absoluteTimeout() {
+				return &Session{record: record}
+			}
+			m.Store.Delete(record.ID)
+		}
+	}
+	return &Session{
+		record: Record{ID: newID(), Created: now},
+		isNew:  true,
+	}
+}
+
+// save stores s and adds its cookie to h. New sessions are only stored once
+// something is set in them.
+func (m *Manager) save(s *Session, h headers.Headers) error {
+	if s.previousID != "" {
+		m.Store.Delete(s.previousID)
+	}
+	c := m.Cookie
+	c.Name = m.cookieName()
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	c.Expires = time.Time{}
+
+	if s.destroyed {
+		if s.isNew {
+			return nil
+		}
+		m.Store.Delete(s.record.ID)
+		c.MaxAge = -1
+		return cookie.Set(h, &c)
+	}
+	if s.isNew && !s.modified {
+		return nil
+	}
+
+	now := m.clock()
+	s.record.Seen = now
+	expires := now.Add(m.idleTimeout())
+	if absolute := s.record.Created.Add(m.absoluteTimeout()); absolute.Before(expires) {
+		expires = absolute
+	}
+	value, err := m.Store.Save(s.record, expires)
+	if err != nil {
+		return err
+	}
+	c.Value = value
+	c.MaxAge = max(int(expires.Sub(now)/time.Second), 1)
+	return cookie.Set(h, &c)
+}
+
+func (m *Manager) cookieName() string {
+	if m.CookieName == "" {
+		return defaultCookieName
+	}
+	return m.CookieName
+}
+
+func (m *Manager) idleTimeout() time.Duration {
+	if m.IdleTimeout <= 0 {
+		return defaultIdleTimeout
+	}
+	return m.IdleTimeout
+}
+
+func (m *Manager) absoluteTimeout() time.Duration {
+	if m.AbsoluteTimeout <= 0 {
+		return defaultAbsoluteTimeout
+	}
+	return m.AbsoluteTimeout
+}
+
+func (m *Manager) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+// sessionTransform saves the session as the response headers go out, which
+// is the last moment a cookie can be set.
+type sessionTransform struct {
+	manager *Manager
+	session *Session
+	saved   bool
+}
+
+func (t *sessionTransform) TransformHeaders(statusCode response.StatusCode, h headers.Headers) {
+	if t.saved || statusCode >= 100 && statusCode < 200 {
+		return
+	}
+	t.saved = true
+	if err := t.manager.save(t.session, h); err != nil {
+		log.Printf("Error saving session: %v", err)
+	}
+	// Responses that set a session cookie must not be shared by caches.
+	if len(h.Values("Set-Cookie")) > 0 && h.Get("Cache-Control") == "" {
+		h.Set("Cache-Control", "private, no-cache")
+	}
+}
+
+func (t *sessionTransform) TransformBody(p []byte) ([]byte, error) {
+	return p, nil
+}
+
+func (t *sessionTransform) Finish(trailer headers.Headers) ([]byte, error) {
+	return nil, nil
+}
+
+// newID returns a random session ID.
+func newID() string {
+	b := make([]byte, idLength)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// cloneRecord copies r so that a store never shares its map with a session.
+func cloneRecord(r Record) Record {
+	r.Values = maps.Clone(r.Values)
+	return r
+}
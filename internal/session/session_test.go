@@ -0,0 +1,209 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip sends a request with the given Cookie header through the
+// manager's middleware and returns the response.
+func roundTrip(t *testing.T, m *Manager, cookieHeader string, handle func(*Session)) *response.Response {
+	t.Helper()
+	req := &request.Request{Headers: headers.Headers{}}
+	if cookieHeader != "" {
+		req.Headers["cookie"] = cookieHeader
+	}
+	var buf bytes.Buffer
+	m.Middleware(func(w *response.Writer, req *request.Request) {
+		s := m.Get(req)
+		require.NotNil(t, s)
+		handle(s)
+		w.WriteStatusLine(response.StatusCodeOk)
+		w.WriteHeaders(headers.Headers{"Content-Length": "0"})
+	})(&response.Writer{Writer: &buf}, req)
+	assert.Nil(t, m.Get(req))
+
+	res, err := response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	return res
+}
+
+// sessionCookie returns the "name=value" part of the session Set-Cookie.
+func sessionCookie(res *response.Response) string {
+	for _, line := range res.Headers.Values("Set-Cookie") {
+		if strings.HasPrefix(line, "session=") {
+			pair, _, _ := strings.Cut(line, ";")
+			return pair
+		}
+	}
+	return ""
+}
+
+func TestMemorySessions(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	m := NewManager(NewMemoryStore())
+	m.now = func() time.Time { return now }
+
+	// Test: An untouched new session sets no cookie
+	res := roundTrip(t, m, "", func(s *Session) { assert.True(t, s.IsNew()) })
+	assert.Empty(t, res.Headers.Values("Set-Cookie"))
+
+	// Test: Setting a value issues a cookie with secure defaults
+	var id string
+	res = roundTrip(t, m, "", func(s *Session) {
+		s.Set("cart", "3 items")
+		id = s.ID()
+	})
+	setCookie := res.Headers.Get("Set-Cookie")
+	assert.Equal(t, "session="+id+"; Path=/; Max-Age=1800; Secure; HttpOnly; SameSite=Lax", setCookie)
+	assert.Equal(t, "private, no-cache", res.Headers.Get("Cache-Control"))
+	anonymous := sessionCookie(res)
+
+	// Test: The session is found again from its cookie
+	roundTrip(t, m, "theme=dark; "+anonymous, func(s *Session) {
+		assert.False(t, s.IsNew())
+		assert.Equal(t, "3 items", s.Get("cart"))
+	})
+
+	// Test: Renewing on login issues a new ID and invalidates the old one
+	res = roundTrip(t, m, anonymous, func(s *Session) {
+		s.Set("user", "ada")
+		s.Renew()
+		assert.NotEqual(t, id, s.ID())
+	})
+	loggedIn := sessionCookie(res)
+	roundTrip(t, m, anonymous, func(s *Session) {
+		assert.True(t, s.IsNew())
+		assert.Empty(t, s.Get("user"))
+	})
+	roundTrip(t, m, loggedIn, func(s *Session) { assert.Equal(t, "ada", s.Get("user")) })
+
+	// Test: Destroy deletes the cookie and the stored session
+	res = roundTrip(t, m, loggedIn, func(s *Session) { s.Destroy() })
+	assert.Equal(t, "session=; Path=/; Max-Age=0; Secure; HttpOnly; SameSite=Lax", res.Headers.Get("Set-Cookie"))
+	roundTrip(t, m, loggedIn, func(s *Session) { assert.True(t, s.IsNew()) })
+}
+
+func TestSessionExpiry(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	m := NewManager(NewMemoryStore())
+	m.IdleTimeout = 10 * time.Minute
+	m.AbsoluteTimeout = time.Hour
+	m.now = func() time.Time { return now }
+
+	res := roundTrip(t, m, "", func(s *Session) { s.Set("user", "ada") })
+	c := sessionCookie(res)
+
+	// Test: Each use slides the idle timeout
+	for range 5 {
+		now = now.Add(9 * time.Minute)
+		res = roundTrip(t, m, c, func(s *Session) { assert.False(t, s.IsNew()) })
+		assert.Equal(t, c, sessionCookie(res))
+	}
+
+	// Test: Max-Age never outlives the absolute timeout
+	now = now.Add(9 * time.Minute)
+	res = roundTrip(t, m, c, func(s *Session) { assert.False(t, s.IsNew()) })
+	assert.Contains(t, res.Headers.Get("Set-Cookie"), "Max-Age=360;")
+
+	// Test: The absolute timeout ends an active session
+	now = now.Add(7 * time.Minute)
+	roundTrip(t, m, c, func(s *Session) { assert.True(t, s.IsNew()) })
+
+	// Test: The idle timeout ends an unused session
+	res = roundTrip(t, m, "", func(s *Session) { s.Set("user", "ada") })
+	c = sessionCookie(res)
+	now = now.Add(10 * time.Minute)
+	roundTrip(t, m, c, func(s *Session) { assert.True(t, s.IsNew()) })
+}
+
+func TestCookieStore(t *testing.T) {
+	oldKey := Key{Sign: bytes.Repeat([]byte{1}, 32)}
+	newKey := Key{Sign: bytes.Repeat([]byte{2}, 32), Encrypt: bytes.Repeat([]byte{3}, 32)}
+	record := Record{ID: "id", Values: map[string]string{"user": "ada"}, Created: time.Now(), Seen: time.Now()}
+	expires := time.Now().Add(time.Hour)
+
+	oldStore, err := NewCookieStore("session", oldKey)
+	require.NoError(t, err)
+	rotated, err := NewCookieStore("session", newKey, oldKey)
+	require.NoError(t, err)
+	newOnly, err := NewCookieStore("session", newKey)
+	require.NoError(t, err)
+
+	// Test: Signed values round-trip
+	value, err := oldStore.Save(record, expires)
+	require.NoError(t, err)
+	loaded, ok := oldStore.Load(value)
+	require.True(t, ok)
+	assert.Equal(t, "ada", loaded.Values["user"])
+
+	// Test: Tampered values and signatures are rejected
+	data, sig, _ := strings.Cut(value, ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(data)
+	forged := base64.RawURLEncoding.EncodeToString(bytes.Replace(payload, []byte("ada"), []byte("bob"), 1))
+	for _, bad := range []string{forged + "." + sig, data + ".AAAA", data, ""} {
+		_, ok = oldStore.Load(bad)
+		assert.False(t, ok, bad)
+	}
+
+	// Test: A cookie for another purpose is rejected even with the same key
+	other, err := NewCookieStore("csrf", oldKey)
+	require.NoError(t, err)
+	_, ok = other.Load(value)
+	assert.False(t, ok)
+
+	// Test: After rotation old cookies still load, new ones use the new key
+	loaded, ok = rotated.Load(value)
+	require.True(t, ok)
+	assert.Equal(t, "ada", loaded.Values["user"])
+	value, err = rotated.Save(loaded, expires)
+	require.NoError(t, err)
+	_, ok = newOnly.Load(value)
+	assert.True(t, ok)
+	_, ok = oldStore.Load(value)
+	assert.False(t, ok)
+
+	// Test: Encrypted values do not reveal the session
+	data, _, _ = strings.Cut(value, ".")
+	payload, _ = base64.RawURLEncoding.DecodeString(data)
+	assert.NotContains(t, string(payload), "ada")
+
+	// Test: Expired and oversized sessions
+	value, err = oldStore.Save(record, time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	_, ok = oldStore.Load(value)
+	assert.False(t, ok)
+	record.Values["big"] = strings.Repeat("x", maxCookieSize)
+	_, err = oldStore.Save(record, expires)
+	assert.ErrorIs(t, err, ErrCookieTooLarge)
+
+	// Test: Invalid keys
+	_, err = NewCookieStore("session")
+	assert.Error(t, err)
+	_, err = NewCookieStore("session", Key{Sign: []byte("k"), Encrypt: []byte("short")})
+	assert.Error(t, err)
+}
+
+func TestCookieStoreSessions(t *testing.T) {
+	store, err := NewCookieStore("session", Key{Sign: bytes.Repeat([]byte{7}, 32)})
+	require.NoError(t, err)
+	m := NewManager(store)
+
+	// Test: Values travel in the cookie itself
+	res := roundTrip(t, m, "", func(s *Session) { s.Set("user", "ada") })
+	c := sessionCookie(res)
+	roundTrip(t, m, c, func(s *Session) {
+		assert.False(t, s.IsNew())
+		assert.Equal(t, "ada", s.Get("user"))
+	})
+}
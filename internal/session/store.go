@@ -0,0 +1,195 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCookieSize is the smallest cookie size browsers must support (RFC 6265
+// section 6.1), counting the name and attributes.
+const maxCookieSize = 4096
+
+// ErrCookieTooLarge is returned by CookieStore.Save for a session whose
+// encoded form cannot fit in a cookie.
+var ErrCookieTooLarge = errors.New("session: too large for a cookie")
+
+// MemoryStore keeps sessions in memory, so the cookie only holds the random
+// session ID. Sessions are lost when the process exits.
+type MemoryStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	record  Record
+	expires time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]memoryEntry)}
+}
+
+func (s *MemoryStore) Load(value string) (Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.sessions[value]
+	if !ok || !time.Now().Before(entry.expires) {
+		return Record{}, false
+	}
+	return cloneRecord(entry.record), true
+}
+
+func (s *MemoryStore) Save(r Record, expires time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	// Expired sessions are swept at most once a minute.
+	if now.Sub(s.lastSweep) > time.Minute {
+		for id, entry := range s.sessions {
+			if !now.Before(entry.expires) {
+				delete(s.sessions, id)
+			}
+		}
+		s.lastSweep = now
+	}
+	s.sessions[r.ID] = memoryEntry{record: cloneRecord(r), expires: expires}
+	return r.ID, nil
+}
+
+func (s *MemoryStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// Key signs, and optionally encrypts, cookies for a CookieStore.
+type Key struct {
+	// Sign is the HMAC-SHA256 key. It should be at least 32 random bytes.
+	Sign []byte
+	// Encrypt, if set, is an AES key of 16, 24 or 32 bytes used to encrypt
+	// the session with AES-GCM, so the client cannot read it.
+	Encrypt []byte
+}
+
+// CookieStore keeps the whole session in the cookie, signed so the client
+// cannot change it. Expiry is carried in the signed data, but a cookie that
+// is deleted from the store cannot be revoked before it expires.
+type CookieStore struct {
+	// name binds cookies to their purpose, so that a cookie signed for one
+	// store is not accepted by another using the same keys.
+	name  string
+	keys  []Key
+	aeads []cipher.AEAD
+}
+
+// NewCookieStore returns a CookieStore for the cookies called name. The
+// first key signs new cookies; all of them are tried when verifying, so a
+// new key can be put first while cookies signed with the old ones remain
+// valid until they are next saved.
+func NewCookieStore(name string, keys ...Key) (*CookieStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("session: no keys")
+	}
+	store := &CookieStore{name: name, keys: keys, aeads: make([]cipher.AEAD, len(keys))}
+	for i, key := range keys {
+		if len(key.Sign) == 0 {
+			return nil, fmt.Errorf("session: key %d has no signing key", i)
+		}
+		if key.Encrypt == nil {
+			continue
+		}
+		block, err := aes.NewCipher(key.Encrypt)
+		if err != nil {
+			return nil, fmt.Errorf("session: key %d: %w", i, err)
+		}
+		if store.aeads[i], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+func (s *CookieStore) Save(r Record, expires time.Time) (string, error) {
+	payload, err := json.Marshal(cookiePayload{Record: r, Expires: expires.Unix()})
+	if err != nil {
+		return "", err
+	}
+	if aead := s.aeads[0]; aead != nil {
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		payload = aead.Seal(nonce, nonce, payload, []byte(s.name))
+	}
+	data := base64.RawURLEncoding.EncodeToString(payload)
+	value := data + "." + base64.RawURLEncoding.EncodeToString(s.mac(s.keys[0], data))
+	if len(s.name)+1+len(value) > maxCookieSize {
+		return "", ErrCookieTooLarge
+	}
+	return value, nil
+}
+
+func (s *CookieStore) Load(value string) (Record, bool) {
+	data, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return Record{}, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return Record{}, false
+	}
+	for i, key := range s.keys {
+		if !hmac.Equal(mac, s.mac(key, data)) {
+			continue
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(data)
+		if err != nil {
+			return Record{}, false
+		}
+		if aead := s.aeads[i]; aead != nil {
+			if len(payload) < aead.NonceSize() {
+				return Record{}, false
+			}
+			nonce, sealed := payload[:aead.NonceSize()], payload[aead.NonceSize():]
+			if payload, err = aead.Open(nil, nonce, sealed, []byte(s.name)); err != nil {
+				return Record{}, false
+			}
+		}
+		var p cookiePayload
+		if err := json.Unmarshal(payload, &p); err != nil || time.Now().Unix() >= p.Expires {
+			return Record{}, false
+		}
+		return p.Record, true
+	}
+	return Record{}, false
+}
+
+// Delete does nothing: the session lives only in the client's cookie, which
+// the Manager expires.
+func (s *CookieStore) Delete(id string) {}
+
+// cookiePayload is what a CookieStore signs.
+type cookiePayload struct {
+	Record
+	Expires int64 `json:"e"`
+}
+
+func (s *CookieStore) mac(key Key, data string) []byte {
+	h := hmac.New(sha256.New, key.Sign)
+	h.Write([]byte(s.name))
+	h.Write([]byte{0})
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,173 @@
+package sse
+
+import (
+	"errors"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a single server-sent event. Empty fields are omitted.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry asks the client to wait this long before reconnecting.
+	Retry time.Duration
+}
+
+var ErrClosed = errors.New("sse: stream closed")
+
+// Stream sends events to a client over a chunked text/event-stream response.
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu       sync.Mutex
+	closed   bool
+	done     chan struct{}
+	doneOnce sync.Once
+	stop     chan struct{}
+}
+
+// NewStream writes the event-stream response headers and starts sending a
+// comment every heartbeat to keep intermediaries from timing out the
+// connection. A zero heartbeat disables it.
+func NewStream(w *response.Writer, req *request.Request, heartbeat time.Duration) (*Stream, error) {
+	s := &Stream{
+		w:           w,
+		lastEventID: req.Headers["last-event-id"],
+		done:        make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+
+	if err := w.WriteStatusLine(response.StatusCodeOk); err != nil {
+		return nil, err
+	}
+	err := w.WriteHeaders(headers.Headers{
+		"Content-Type":      "text/event-stream",
+		"Cache-Control":     "no-cache",
+		"Transfer-Encoding": "chunked",
+		"Connection":        "close",
+		"X-Accel-Buffering": "no",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Writers that can tell say when the client goes away. Elsewhere a
+	// disconnect is only noticed when a write fails.
+	if notifier, ok := w.Writer.(response.CloseNotifier); ok {
+		gone := notifier.CloseNotify()
+		go func() {
+			select {
+			case <-gone:
+				s.disconnected()
+			case <-s.stop:
+			}
+		}()
+	}
+	if heartbeat > 0 {
+		go s.heartbeat(heartbeat)
+	}
+	return s, nil
+}
+
+// LastEventID returns the Last-Event-ID sent by a reconnecting client.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed once the client has disconnected or the stream is closed.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Send writes e to the client immediately.
+func (s *Stream) Send(e Event) error {
+	if strings.ContainsAny(e.ID, "\r\n\x00") || strings.ContainsAny(e.Event, "\r\n") {
+		return errors.New("sse: event id and name must not contain line breaks")
+	}
+
+	var b strings.Builder
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	// Each line of the data gets its own field; the client joins them with \n.
+	data := strings.ReplaceAll(strings.ReplaceAll(e.Data, "\r\n", "\n"), "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	return s.write(": " + strings.ReplaceAll(text, "\n", " ") + "\n\n")
+}
+
+// Close ends the response. It is safe to call more than once.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	s.disconnected()
+	_, err := s.w.WriteChunkedDone()
+	return err
+}
+
+func (s *Stream) write(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	select {
+	case <-s.done:
+		return ErrClosed
+	default:
+	}
+	if _, err := s.w.WriteChunkerBody([]byte(text)); err != nil {
+		s.disconnected()
+		return err
+	}
+	return nil
+}
+
+func (s *Stream) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Comment("heartbeat"); err != nil {
+				return
+			}
+		case <-s.stop:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Stream) disconnected() {
+	s.doneOnce.Do(func() {
+		close(s.done)
+	})
+}
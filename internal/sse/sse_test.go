@@ -0,0 +1,105 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamEvents(t *testing.T) {
+	var buf bytes.Buffer
+	req := &request.Request{Headers: map[string]string{"last-event-id": "41"}}
+	s, err := NewStream(&response.Writer{Writer: &buf}, req, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "41", s.LastEventID())
+
+	require.NoError(t, s.Send(Event{ID: "42", Event: "update", Data: "line one\nline two", Retry: 3 * time.Second}))
+	require.NoError(t, s.Send(Event{Data: "plain"}))
+	require.NoError(t, s.Comment("still here"))
+	require.Error(t, s.Send(Event{ID: "4\n2"}))
+	require.NoError(t, s.Close())
+	require.NoError(t, s.Close())
+	require.ErrorIs(t, s.Send(Event{Data: "late"}), ErrClosed)
+
+	res, err := response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "text/event-stream", res.Headers.Get("Content-Type"))
+	assert.Equal(t, "no-cache", res.Headers.Get("Cache-Control"))
+	assert.Equal(t, "event: update\n"+
+		"id: 42\n"+
+		"retry: 3000\n"+
+		"data: line one\n"+
+		"data: line two\n"+
+		"\n"+
+		"data: plain\n"+
+		"\n"+
+		": still here\n"+
+		"\n", string(res.Body))
+}
+
+func TestStreamHeartbeatAndDisconnect(t *testing.T) {
+	client, serverSide := net.Pipe()
+	defer serverSide.Close()
+
+	streamCh := make(chan *Stream, 1)
+	go func() {
+		s, err := NewStream(&response.Writer{Writer: serverSide}, &request.Request{}, 10*time.Millisecond)
+		assert.NoError(t, err)
+		streamCh <- s
+	}()
+
+	// Test: Heartbeat comments arrive without any events being sent
+	reader := bufio.NewReader(client)
+	found := false
+	for i := 0; i < 20 && !found; i++ {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		found = strings.Contains(line, ": heartbeat")
+	}
+	assert.True(t, found)
+	s := <-streamCh
+
+	// Test: Client disconnect closes Done
+	client.Close()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream did not notice the client disconnecting")
+	}
+	require.Error(t, s.Send(Event{Data: "gone"}))
+}
+
+// notifyingWriter stands in for an HTTP/2 stream, which says when the client
+// resets it.
+type notifyingWriter struct {
+	bytes.Buffer
+	gone chan struct{}
+}
+
+func (w *notifyingWriter) CloseNotify() <-chan struct{} {
+	return w.gone
+}
+
+func TestStreamCloseNotify(t *testing.T) {
+	w := &notifyingWriter{gone: make(chan struct{})}
+	s, err := NewStream(&response.Writer{Writer: w}, &request.Request{}, time.Hour)
+	require.NoError(t, err)
+
+	// Test: A writer-level close signal closes Done without a write failing
+	close(w.gone)
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream did not notice the client disconnecting")
+	}
+	require.ErrorIs(t, s.Send(Event{Data: "gone"}), ErrClosed)
+}
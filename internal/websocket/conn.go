@@ -0,0 +1,396 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the opcode of a data or control frame.
+type MessageType int
+
+const (
+	continuationFrame MessageType = 0x0
+	TextMessage       MessageType = 0x1
+	BinaryMessage     MessageType = 0x2
+	CloseMessage      MessageType = 0x8
+	PingMessage       MessageType = 0x9
+	PongMessage       MessageType = 0xA
+)
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure     = 1000
+	CloseGoingAway         = 1001
+	CloseProtocolError     = 1002
+	CloseUnsupportedData   = 1003
+	CloseNoStatusReceived  = 1005
+	CloseInvalidPayload    = 1007
+	ClosePolicyViolation   = 1008
+	CloseMessageTooBig     = 1009
+	CloseInternalServerErr = 1011
+)
+
+const (
+	maxControlPayload     = 125
+	defaultMaxMessageSize = 32 << 20
+	closeTimeout          = 5 * time.Second
+)
+
+// CloseError is returned by ReadMessage once the peer has closed the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Reason)
+}
+
+var errProtocol = errors.New("websocket: protocol error")
+
+// Conn is the server side of a WebSocket connection.
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeMu    sync.Mutex
+	closeSent  bool
+	closedRead bool
+
+	// MaxMessageSize limits the size of a reassembled message.
+	MaxMessageSize int
+	// PongHandler, if set, is called with the payload of each pong received.
+	PongHandler func(payload []byte)
+}
+
+func newConn(conn net.Conn, r io.Reader) *Conn {
+	return &Conn{
+		conn:           conn,
+		reader:         bufio.NewReader(r),
+		MaxMessageSize: defaultMaxMessageSize,
+	}
+}
+
+// frame is a single decoded frame.
+type frame struct {
+	fin     bool
+	opcode  MessageType
+	payload []byte
+}
+
+// ReadMessage returns the next complete text or binary message, reassembling
+// fragments. Pings are answered automatically. When the peer closes the
+// connection the close is acknowledged and a *CloseError is returned.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.closedRead {
+		return 0, nil, io.EOF
+	}
+
+	var messageType MessageType
+	var message []byte
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			c.failConnection(err)
+			return 0, nil, err
+		}
+
+		switch f.opcode {
+		case PingMessage:
+			if err := c.writeFrame(PongMessage, f.payload, true); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if c.PongHandler != nil {
+				c.PongHandler(f.payload)
+			}
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(f.payload)
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				err := fmt.Errorf("%w: new message inside a fragmented one", errProtocol)
+				c.failConnection(err)
+				return 0, nil, err
+			}
+			messageType = f.opcode
+		case continuationFrame:
+			if messageType == 0 {
+				err := fmt.Errorf("%w: continuation without a message", errProtocol)
+				c.failConnection(err)
+				return 0, nil, err
+			}
+		}
+
+		if len(message)+len(f.payload) > c.MaxMessageSize {
+			c.WriteClose(CloseMessageTooBig, "message too big")
+			c.closedRead = true
+			return 0, nil, &CloseError{Code: CloseMessageTooBig, Reason: "message too big"}
+		}
+		message = append(message, f.payload...)
+
+		if f.fin {
+			if messageType == TextMessage && !utf8.Valid(message) {
+				c.WriteClose(CloseInvalidPayload, "invalid UTF-8")
+				c.closedRead = true
+				return 0, nil, &CloseError{Code: CloseInvalidPayload, Reason: "invalid UTF-8"}
+			}
+			return messageType, message, nil
+		}
+	}
+}
+
+// readFrame reads and unmasks a single frame, enforcing the framing rules of
+// RFC 6455 section 5.
+func (c *Conn) readFrame() (frame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		fin:    header[0]&0x80 != 0,
+		opcode: MessageType(header[0] & 0x0F),
+	}
+	if header[0]&0x70 != 0 {
+		return frame{}, fmt.Errorf("%w: reserved bits set", errProtocol)
+	}
+	masked := header[1]&0x80 != 0
+	if !masked {
+		return frame{}, fmt.Errorf("%w: client frames must be masked", errProtocol)
+	}
+
+	switch f.opcode {
+	case continuationFrame, TextMessage, BinaryMessage:
+	case CloseMessage, PingMessage, PongMessage:
+		if !f.fin {
+			return frame{}, fmt.Errorf("%w: fragmented control frame", errProtocol)
+		}
+	default:
+		return frame{}, fmt.Errorf("%w: unknown opcode %d", errProtocol, f.opcode)
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length&(1<<63) != 0 {
+			return frame{}, fmt.Errorf("%w: invalid payload length", errProtocol)
+		}
+	}
+	if f.opcode >= CloseMessage && length > maxControlPayload {
+		return frame{}, fmt.Errorf("%w: control frame too long", errProtocol)
+	}
+	if length > uint64(c.MaxMessageSize) {
+		return frame{}, &CloseError{Code: CloseMessageTooBig, Reason: "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return frame{}, err
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, f.payload); err != nil {
+		return frame{}, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+// handleClose answers a close frame from the peer and reports it.
+func (c *Conn) handleClose(payload []byte) error {
+	c.closedRead = true
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		c.WriteClose(CloseProtocolError, "invalid close payload")
+		return &CloseError{Code: CloseProtocolError}
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) || !utf8.ValidString(closeErr.Reason) {
+			c.WriteClose(CloseProtocolError, "invalid close payload")
+			return &CloseError{Code: CloseProtocolError}
+		}
+	}
+
+	// Echo the status code back, as recommended by RFC 6455 section 5.5.1.
+	code := closeErr.Code
+	if code == CloseNoStatusReceived {
+		code = CloseNormalClosure
+	}
+	c.WriteClose(code, "")
+	return closeErr
+}
+
+// failConnection closes the connection after a protocol violation.
+func (c *Conn) failConnection(err error) {
+	var closeErr *CloseError
+	switch {
+	case errors.Is(err, errProtocol):
+		c.WriteClose(CloseProtocolError, "")
+	case errors.As(err, &closeErr):
+		c.WriteClose(closeErr.Code, closeErr.Reason)
+	}
+	c.closedRead = true
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code >= 1000 && code <= 1011:
+		return code != 1004 && code != 1005 && code != 1006
+	}
+	return false
+}
+
+// WriteMessage sends data as a single unfragmented message.
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return errors.New("websocket: WriteMessage only sends text or binary messages")
+	}
+	return c.writeFrame(messageType, data, true)
+}
+
+// NextWriter returns a writer that sends each Write as one fragment of a
+// message. Closing the writer sends the final fragment.
+func (c *Conn) NextWriter(messageType MessageType) io.WriteCloser {
+	return &fragmentWriter{conn: c, opcode: messageType}
+}
+
+// WritePing sends a ping with an optional payload of up to 125 bytes.
+func (c *Conn) WritePing(payload []byte) error {
+	if len(payload) > maxControlPayload {
+		return errors.New("websocket: ping payload too long")
+	}
+	return c.writeFrame(PingMessage, payload, true)
+}
+
+// WriteClose sends a close frame. Only the first call has any effect. A
+// reason too long for the frame is cut short at a character boundary.
+func (c *Conn) WriteClose(code int, reason string) error {
+	if n := maxControlPayload - 2; len(reason) > n {
+		for n > 0 && !utf8.RuneStart(reason[n]) {
+			n--
+		}
+		reason = reason[:n]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	return c.writeFrame(CloseMessage, payload, true)
+}
+
+// Close performs the closing handshake: it sends a close frame, waits briefly
+// for the peer's close and then closes the underlying connection.
+func (c *Conn) Close(code int, reason string) error {
+	if err := c.WriteClose(code, reason); err != nil {
+		c.conn.Close()
+		return err
+	}
+	if !c.closedRead {
+		c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		for {
+			f, err := c.readFrame()
+			if err != nil || f.opcode == CloseMessage {
+				break
+			}
+		}
+		c.closedRead = true
+	}
+	return c.conn.Close()
+}
+
+// writeFrame writes an unmasked server frame, serialising concurrent
+// writers. Nothing is sent after the close frame, and a second close frame
+// is silently dropped.
+func (c *Conn) writeFrame(opcode MessageType, payload []byte, fin bool) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		if opcode == CloseMessage {
+			return nil
+		}
+		return errors.New("websocket: close already sent")
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	header := make([]byte, 2, 10)
+	header[0] = byte(opcode)
+	if fin {
+		header[0] |= 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// fragmentWriter sends a message as a sequence of fragments.
+type fragmentWriter struct {
+	conn    *Conn
+	opcode  MessageType
+	started bool
+	closed  bool
+}
+
+func (fw *fragmentWriter) Write(p []byte) (int, error) {
+	if fw.closed {
+		return 0, errors.New("websocket: write to closed message writer")
+	}
+	opcode := continuationFrame
+	if !fw.started {
+		opcode = fw.opcode
+		fw.started = true
+	}
+	if err := fw.conn.writeFrame(opcode, p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (fw *fragmentWriter) Close() error {
+	if fw.closed {
+		return nil
+	}
+	fw.closed = true
+	opcode := continuationFrame
+	if !fw.started {
+		opcode = fw.opcode
+	}
+	return fw.conn.writeFrame(opcode, nil, true)
+}
@@ -0,0 +1,104 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net/http"
+)
+
+// acceptGUID is appended to the client key to compute Sec-WebSocket-Accept (RFC 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// HandshakeError is returned by Upgrade when the connection was not
+// upgraded. Nothing has been written to the client: the caller should answer
+// with StatusCode, adding Headers to the response.
+type HandshakeError struct {
+	StatusCode int
+	Headers    headers.Headers
+	Err        error
+}
+
+func (e *HandshakeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// Upgrade validates the opening handshake in req, hijacks the connection,
+// answers with 101 Switching Protocols and returns the connection for
+// exchanging messages. The caller must Close it. If the connection cannot be
+// upgraded a *HandshakeError is returned and the caller writes the response.
+func Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	if err := checkHandshake(req); err != nil {
+		hsErr := &HandshakeError{StatusCode: http.StatusBadRequest, Err: err}
+		if req.Headers["sec-websocket-version"] != "13" {
+			hsErr.StatusCode = http.StatusUpgradeRequired
+			hsErr.Headers = headers.Headers{"Sec-WebSocket-Version": "13"}
+		}
+		return nil, hsErr
+	}
+
+	netConn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, &HandshakeError{StatusCode: http.StatusInternalServerError, Err: err}
+	}
+
+	// The connection now belongs to us, so the response goes straight to it.
+	hw := &response.Writer{Writer: netConn}
+	if err := hw.WriteStatusLine(http.StatusSwitchingProtocols); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	err = hw.WriteHeaders(headers.Headers{
+		"Upgrade":              "websocket",
+		"Connection":           "Upgrade",
+		"Sec-WebSocket-Accept": acceptKey(req.Headers["sec-websocket-key"]),
+	})
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return newConn(netConn, io.MultiReader(bytes.NewReader(buffered), netConn)), nil
+}
+
+// checkHandshake validates the client's opening handshake (RFC 6455 section 4.2.1).
+func checkHandshake(req *request.Request) error {
+	if req.RequestLine.Method != "GET" {
+		return fmt.Errorf("%w: method must be GET", ErrBadHandshake)
+	}
+	if req.RequestLine.HTTPVersion != "1.1" {
+		return fmt.Errorf("%w: HTTP/1.1 required", ErrBadHandshake)
+	}
+	if !headers.HasToken(req.Headers["upgrade"], "websocket") {
+		return fmt.Errorf("%w: missing Upgrade: websocket", ErrBadHandshake)
+	}
+	if !headers.HasToken(req.Headers["connection"], "upgrade") {
+		return fmt.Errorf("%w: missing Connection: Upgrade", ErrBadHandshake)
+	}
+	if req.Headers["sec-websocket-version"] != "13" {
+		return fmt.Errorf("%w: unsupported Sec-WebSocket-Version", ErrBadHandshake)
+	}
+	key, err := base64.StdEncoding.DecodeString(req.Headers["sec-websocket-key"])
+	if err != nil || len(key) != 16 {
+		return fmt.Errorf("%w: invalid Sec-WebSocket-Key", ErrBadHandshake)
+	}
+	return nil
+}
+
+// acceptKey computes the Sec-WebSocket-Accept value for a client key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,284 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const handshake = "GET /chat HTTP/1.1\r\n" +
+	"Host: localhost:42069\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: keep-alive, Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n" +
+	"\r\n"
+
+// clientFrame builds a masked frame as a client would send it.
+func clientFrame(fin bool, opcode MessageType, payload []byte) []byte {
+	b := byte(opcode)
+	if fin {
+		b |= 0x80
+	}
+	out := []byte{b}
+	switch {
+	case len(payload) <= 125:
+		out = append(out, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		out = append(out, 0x80|126)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(payload)))
+	default:
+		out = append(out, 0x80|127)
+		out = binary.BigEndian.AppendUint64(out, uint64(len(payload)))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	out = append(out, mask...)
+	for i, c := range payload {
+		out = append(out, c^mask[i%4])
+	}
+	return out
+}
+
+// readServerFrame reads one unmasked frame sent by the server.
+func readServerFrame(t *testing.T, r *bufio.Reader) (bool, MessageType, []byte) {
+	t.Helper()
+	var header [2]byte
+	_, err := io.ReadFull(r, header[:])
+	require.NoError(t, err)
+	require.Zero(t, header[1]&0x80, "server frames must not be masked")
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		_, err = io.ReadFull(r, ext[:])
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	require.NoError(t, err)
+	return header[0]&0x80 != 0, MessageType(header[0] & 0x0F), payload
+}
+
+// upgrade performs the handshake over an in-memory connection.
+func upgrade(t *testing.T) (*Conn, net.Conn, *bufio.Reader) {
+	t.Helper()
+	client, serverSide := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		serverSide.Close()
+	})
+
+	req, err := request.RequestFromReader(strings.NewReader(handshake))
+	require.NoError(t, err)
+
+	connCh := make(chan *Conn, 1)
+	go func() {
+		conn, err := Upgrade(&response.Writer{Writer: serverSide}, req)
+		assert.NoError(t, err)
+		connCh <- conn
+	}()
+
+	reader := bufio.NewReader(client)
+	res, err := readHandshakeResponse(reader)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(101), res.StatusLine.StatusCode)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", res.Headers.Get("Sec-WebSocket-Accept"))
+	assert.Equal(t, "websocket", res.Headers.Get("Upgrade"))
+	return <-connCh, client, reader
+}
+
+// readHandshakeResponse reads the status line and headers of the 101 response.
+func readHandshakeResponse(r *bufio.Reader) (*response.Response, error) {
+	var raw strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		raw.WriteString(line)
+		if line == "\r\n" {
+			break
+		}
+	}
+	return response.ResponseFromReader(strings.NewReader(raw.String()))
+}
+
+func TestUpgradeRejectsBadHandshakes(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		status response.StatusCode
+	}{
+		{"wrong method", strings.Replace(handshake, "GET", "POST", 1), 400},
+		{"missing upgrade", strings.Replace(handshake, "Upgrade: websocket\r\n", "", 1), 400},
+		{"missing connection token", strings.Replace(handshake, "keep-alive, Upgrade", "keep-alive", 1), 400},
+		{"short key", strings.Replace(handshake, "dGhlIHNhbXBsZSBub25jZQ==", "c2hvcnQ=", 1), 400},
+		{"wrong version", strings.Replace(handshake, "Version: 13", "Version: 8", 1), 426},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := request.RequestFromReader(strings.NewReader(tt.raw))
+			require.NoError(t, err)
+			var buf strings.Builder
+			_, err = Upgrade(&response.Writer{Writer: &buf}, req)
+			require.ErrorIs(t, err, ErrBadHandshake)
+			var hsErr *HandshakeError
+			require.True(t, errors.As(err, &hsErr))
+			assert.Equal(t, int(tt.status), hsErr.StatusCode)
+			assert.Empty(t, buf.String(), "the caller writes the response")
+		})
+	}
+}
+
+func TestConnMessages(t *testing.T) {
+	conn, client, reader := upgrade(t)
+
+	// Test: Fragmented text message with an interleaved ping
+	go func() {
+		client.Write(clientFrame(false, TextMessage, []byte("hello ")))
+		client.Write(clientFrame(true, PingMessage, []byte("are you there")))
+		client.Write(clientFrame(true, continuationFrame, []byte("world")))
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fin, opcode, payload := readServerFrame(t, reader)
+		assert.True(t, fin)
+		assert.Equal(t, PongMessage, opcode)
+		assert.Equal(t, "are you there", string(payload))
+	}()
+	messageType, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, messageType)
+	assert.Equal(t, "hello world", string(message))
+	<-done
+
+	// Test: Binary message with a 16-bit length
+	payload := []byte(strings.Repeat("x", 300))
+	go client.Write(clientFrame(true, BinaryMessage, payload))
+	messageType, message, err = conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, messageType)
+	assert.Equal(t, payload, message)
+
+	// Test: Server writes whole and fragmented messages
+	go func() {
+		conn.WriteMessage(TextMessage, []byte("pushed"))
+		w := conn.NextWriter(BinaryMessage)
+		w.Write([]byte("ab"))
+		w.Write([]byte("cd"))
+		w.Close()
+	}()
+	fin, opcode, data := readServerFrame(t, reader)
+	assert.True(t, fin)
+	assert.Equal(t, TextMessage, opcode)
+	assert.Equal(t, "pushed", string(data))
+	fin, opcode, data = readServerFrame(t, reader)
+	assert.False(t, fin)
+	assert.Equal(t, BinaryMessage, opcode)
+	assert.Equal(t, "ab", string(data))
+	fin, opcode, data = readServerFrame(t, reader)
+	assert.False(t, fin)
+	assert.Equal(t, continuationFrame, opcode)
+	assert.Equal(t, "cd", string(data))
+	fin, opcode, data = readServerFrame(t, reader)
+	assert.True(t, fin)
+	assert.Equal(t, continuationFrame, opcode)
+	assert.Empty(t, data)
+
+	// Test: Close handshake initiated by the client
+	closePayload := binary.BigEndian.AppendUint16(nil, CloseGoingAway)
+	go client.Write(clientFrame(true, CloseMessage, append(closePayload, "bye"...)))
+	go func() {
+		_, opcode, data := readServerFrame(t, reader)
+		assert.Equal(t, CloseMessage, opcode)
+		assert.Equal(t, uint16(CloseGoingAway), binary.BigEndian.Uint16(data))
+	}()
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	require.True(t, errors.As(err, &closeErr))
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+	assert.Equal(t, "bye", closeErr.Reason)
+}
+
+func TestConnProtocolErrors(t *testing.T) {
+	// Test: Unmasked client frame
+	conn, client, reader := upgrade(t)
+	go client.Write([]byte{0x81, 0x02, 'h', 'i'})
+	go func() {
+		_, opcode, data := readServerFrame(t, reader)
+		assert.Equal(t, CloseMessage, opcode)
+		assert.Equal(t, uint16(CloseProtocolError), binary.BigEndian.Uint16(data))
+	}()
+	_, _, err := conn.ReadMessage()
+	require.ErrorIs(t, err, errProtocol)
+
+	// Test: Fragmented control frame
+	conn, client, reader = upgrade(t)
+	go client.Write(clientFrame(false, PingMessage, []byte("x")))
+	go readServerFrame(t, reader)
+	_, _, err = conn.ReadMessage()
+	require.ErrorIs(t, err, errProtocol)
+
+	// Test: Invalid UTF-8 in a text message
+	conn, client, reader = upgrade(t)
+	go client.Write(clientFrame(true, TextMessage, []byte{0xff, 0xfe}))
+	go func() {
+		_, opcode, data := readServerFrame(t, reader)
+		assert.Equal(t, CloseMessage, opcode)
+		assert.Equal(t, uint16(CloseInvalidPayload), binary.BigEndian.Uint16(data))
+	}()
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	require.True(t, errors.As(err, &closeErr))
+	assert.Equal(t, CloseInvalidPayload, closeErr.Code)
+
+	// Test: A single frame over MaxMessageSize closes with 1009
+	conn, client, reader = upgrade(t)
+	conn.MaxMessageSize = 4
+	go client.Write(clientFrame(true, BinaryMessage, []byte("too long")))
+	go func() {
+		_, opcode, data := readServerFrame(t, reader)
+		assert.Equal(t, CloseMessage, opcode)
+		assert.Equal(t, uint16(CloseMessageTooBig), binary.BigEndian.Uint16(data))
+	}()
+	_, _, err = conn.ReadMessage()
+	require.True(t, errors.As(err, &closeErr))
+	assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+
+	// Test: Continuation without a started message
+	conn, client, reader = upgrade(t)
+	go client.Write(clientFrame(true, continuationFrame, []byte("x")))
+	go readServerFrame(t, reader)
+	_, _, err = conn.ReadMessage()
+	require.ErrorIs(t, err, errProtocol)
+}
+
+func TestWriteClose(t *testing.T) {
+	conn, _, reader := upgrade(t)
+
+	// Test: A long reason is cut at a character boundary
+	reason := strings.Repeat("é", 100)
+	go conn.WriteClose(CloseNormalClosure, reason)
+	_, opcode, data := readServerFrame(t, reader)
+	assert.Equal(t, CloseMessage, opcode)
+	assert.LessOrEqual(t, len(data), maxControlPayload)
+	assert.True(t, utf8.Valid(data[2:]))
+	assert.Equal(t, strings.Repeat("é", 61), string(data[2:]))
+
+	// Test: Nothing is sent after the close frame
+	assert.NoError(t, conn.WriteClose(CloseNormalClosure, ""))
+	assert.Error(t, conn.WriteMessage(TextMessage, []byte("late")))
+}